@@ -1,6 +1,9 @@
 package main
 
-import "github.com/urfave/cli/v2"
+import (
+	"github.com/urfave/cli/v2"
+	"time"
+)
 
 var (
 	ConfigFlag = &cli.StringFlag{
@@ -39,4 +42,33 @@ var (
 		Name:  "n",
 		Usage: "list top `N` collections",
 	}
+
+	RaritySlugFlag = &cli.StringSliceFlag{
+		Name:  "slug",
+		Usage: "rank the collection of `slug`, all projects if not set",
+	}
+	RarityCachedFlag = &cli.BoolFlag{
+		Name:  "cached",
+		Usage: "rank the cached traits only, don't download",
+	}
+
+	BackfillContractFlag = &cli.StringFlag{
+		Name:     "contract",
+		Required: true,
+		Usage:    "backfill events of the contract `address`",
+	}
+	BackfillFromFlag = &cli.StringFlag{
+		Name:     "from",
+		Required: true,
+		Usage:    "backfill from this `time`, e.g. 2021-09-01, 2021-09-01T08:00:00+08:00 or unix seconds",
+	}
+	BackfillToFlag = &cli.StringFlag{
+		Name:  "to",
+		Usage: "backfill to this `time`, default now",
+	}
+	BackfillStepFlag = &cli.DurationFlag{
+		Name:  "step",
+		Value: time.Hour,
+		Usage: "save a checkpoint every `step`",
+	}
 )
@@ -23,6 +23,7 @@ func init() {
 		collectionCommand,
 		eventCommand,
 		botCommand,
+		filterCommand,
 		//openseaCommand,
 	}
 	app.Flags = []cli.Flag{
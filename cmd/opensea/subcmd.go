@@ -1,9 +1,16 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"github.com/urfave/cli/v2"
 	"github.com/xyths/hs"
 	"github.com/xyths/opensea-monitor/opensea"
+	"github.com/xyths/opensea-monitor/telegram"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -33,6 +40,15 @@ var (
 					CollectionListNFlag,
 				},
 			},
+			{
+				Action: rankRarity,
+				Name:   "rarity",
+				Usage:  "Download the traits of all tokens of the collections and rank their rarity",
+				Flags: []cli.Flag{
+					RaritySlugFlag,
+					RarityCachedFlag,
+				},
+			},
 		},
 	}
 	eventCommand = &cli.Command{
@@ -42,21 +58,22 @@ var (
 			{
 				Action: monitor,
 				Name:   "monitor",
-				Usage:  "Monitor OpenSea events of the NFTs",
+				Usage:  "Monitor OpenSea events of the NFTs and save them for bots",
+				Flags:  []cli.Flag{},
+			},
+			{
+				Action: backfill,
+				Name:   "backfill",
+				Usage:  "Download historical OpenSea events of a contract into MongoDB, no message is sent",
 				Flags: []cli.Flag{
+					BackfillContractFlag,
+					BackfillFromFlag,
+					BackfillToFlag,
+					BackfillStepFlag,
 				},
 			},
 		},
 	}
-	//downloadCommand = &cli.Command{
-	//	Action: download,
-	//	Name:   "download",
-	//	Usage:  "Download meta into mongodb",
-	//	Flags: []cli.Flag{
-	//		FromFlag,
-	//		ToFlag,
-	//	},
-	//}
 	botCommand = &cli.Command{
 		Name:  "bot",
 		Usage: "Start bot for OpenSea message dispatch",
@@ -65,7 +82,19 @@ var (
 				Action:  telegramBot,
 				Name:    "telegram",
 				Aliases: []string{"tg"},
-				Usage:   "Start a telegram bot",
+				Usage:   "Start a telegram bot, send events saved by event monitor",
+			},
+		},
+	}
+	filterCommand = &cli.Command{
+		Name:  "filter",
+		Usage: "Chat filter expressions",
+		Subcommands: []*cli.Command{
+			{
+				Action:    checkFilter,
+				Name:      "check",
+				Usage:     "Check a filter expression before saving it into the chat preferences",
+				ArgsUsage: "<expression>",
 			},
 		},
 	}
@@ -135,6 +164,20 @@ func listTopCollection(c *cli.Context) error {
 	return nil
 }
 
+func rankRarity(c *cli.Context) error {
+	configFile := c.String(ConfigFlag.Name)
+	cfg := opensea.RarityConfig{}
+	if err := hs.ParseJsonConfig(configFile, &cfg); err != nil {
+		return err
+	}
+	r := opensea.NewRarityRanker(cfg)
+	if err := r.Init(c.Context); err != nil {
+		return err
+	}
+	defer r.Close(c.Context)
+	return r.Rank(c.Context, c.StringSlice(RaritySlugFlag.Name), c.Bool(RarityCachedFlag.Name))
+}
+
 func monitor(c *cli.Context) error {
 	configFile := c.String(ConfigFlag.Name)
 	cfg := opensea.Config{}
@@ -152,19 +195,80 @@ func monitor(c *cli.Context) error {
 	return nil
 }
 
+func backfill(c *cli.Context) error {
+	configFile := c.String(ConfigFlag.Name)
+	from, err := parseTime(c.String(BackfillFromFlag.Name))
+	if err != nil {
+		return err
+	}
+	to := time.Now()
+	if c.IsSet(BackfillToFlag.Name) {
+		if to, err = parseTime(c.String(BackfillToFlag.Name)); err != nil {
+			return err
+		}
+	}
+	cfg := opensea.BackfillConfig{}
+	if err = hs.ParseJsonConfig(configFile, &cfg); err != nil {
+		return err
+	}
+	b := opensea.NewBackfill(cfg)
+	if err = b.Init(c.Context); err != nil {
+		return err
+	}
+	defer b.Close(c.Context)
+	return b.Run(c.Context, c.String(BackfillContractFlag.Name), from, to, c.Duration(BackfillStepFlag.Name))
+}
+
+// parseTime parses date, RFC3339 time or unix seconds.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("time %s format error, should be like 2021-09-01, 2021-09-01T08:00:00+08:00 or unix seconds", value)
+}
+
+// checkFilter prints the error of the expression with a caret under its position.
+func checkFilter(c *cli.Context) error {
+	expr := strings.Join(c.Args().Slice(), " ")
+	err := opensea.CheckFilter(expr)
+	var e *opensea.ExprError
+	if !errors.As(err, &e) {
+		if err == nil {
+			fmt.Println("ok")
+		}
+		return err
+	}
+	start := strings.LastIndex(expr[:e.Offset], "\n") + 1
+	end := strings.IndexByte(expr[e.Offset:], '\n')
+	if end < 0 {
+		end = len(expr)
+	} else {
+		end += e.Offset
+	}
+	fmt.Println(expr[start:end])
+	fmt.Println(strings.Repeat(" ", utf8.RuneCountInString(expr[start:e.Offset])) + "^")
+	return err
+}
+
 func telegramBot(c *cli.Context) error {
-	//configFile := c.String(ConfigFlag.Name)
-	//cfg := telegram.Config{}
-	//if err := hs.ParseJsonConfig(configFile, &cfg); err != nil {
-	//	return err
-	//}
-	//bot := telegram.New(cfg)
-	//if err := bot.Init(c.Context); err != nil {
-	//	return err
-	//}
-	//defer bot.Close(c.Context)
-	//if err := bot.Serve(c.Context); err != nil {
-	//	return err
-	//}
+	configFile := c.String(ConfigFlag.Name)
+	cfg := telegram.Config{}
+	if err := hs.ParseJsonConfig(configFile, &cfg); err != nil {
+		return err
+	}
+	bot := telegram.New(cfg)
+	if err := bot.Init(c.Context); err != nil {
+		return err
+	}
+	defer bot.Close(c.Context)
+	if err := bot.Serve(c.Context); err != nil {
+		return err
+	}
 	return nil
 }
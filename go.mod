@@ -6,6 +6,7 @@ require (
 	github.com/bwmarrin/discordgo v0.23.2
 	github.com/ethereum/go-ethereum v1.10.8
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
+	github.com/gorilla/websocket v1.4.2
 	github.com/shopspring/decimal v1.2.0
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
 	github.com/urfave/cli/v2 v2.3.0
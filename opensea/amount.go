@@ -0,0 +1,175 @@
+package opensea
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+// Decimal is a decimal number saved as Decimal128 in MongoDB, so prices can be compared and summed in queries.
+type Decimal struct {
+	decimal.Decimal
+}
+
+func NewDecimal(d decimal.Decimal) *Decimal {
+	return &Decimal{Decimal: d}
+}
+
+func (d Decimal) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	v, err := primitive.ParseDecimal128(d.String())
+	if err != nil {
+		return 0, nil, err
+	}
+	return bson.MarshalValue(v)
+}
+
+// UnmarshalBSONValue also reads the prices saved as text before, e.g. "1.5 ETH".
+func (d *Decimal) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.Decimal128:
+		v, err := decimal.NewFromString(raw.Decimal128().String())
+		if err != nil {
+			return err
+		}
+		d.Decimal = v
+	case bsontype.String:
+		v, ok := priceValue(raw.StringValue())
+		if !ok {
+			return fmt.Errorf("price %s format error", raw.StringValue())
+		}
+		d.Decimal = v
+	case bsontype.Double:
+		d.Decimal = decimal.NewFromFloat(raw.Double())
+	case bsontype.Int32, bsontype.Int64:
+		d.Decimal = decimal.NewFromInt(raw.AsInt64())
+	case bsontype.Null:
+		d.Decimal = decimal.Zero
+	default:
+		return fmt.Errorf("can't decode %s into Decimal", t)
+	}
+	return nil
+}
+
+// UnmarshalBSON reads the records saved before Decimal too, the empty text price of Mint, Transfer and Digest is nil.
+func (r *Record) UnmarshalBSON(data []byte) error {
+	type plain Record // without UnmarshalBSON
+	if v, err := bson.Raw(data).LookupErr("price"); err == nil && v.Type == bsontype.String && v.StringValue() == "" {
+		var doc bson.D
+		if err = bson.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		for i, e := range doc {
+			if e.Key == "price" {
+				doc = append(doc[:i], doc[i+1:]...)
+				break
+			}
+		}
+		if data, err = bson.Marshal(doc); err != nil {
+			return err
+		}
+	}
+	return bson.Unmarshal(data, (*plain)(r))
+}
+
+// Token is the payment token of a price.
+type Token struct {
+	Symbol   string `json:"symbol" bson:"symbol"`
+	Address  string `json:"address" bson:"address"` // checksum address, the zero address for ETH
+	Decimals int    `json:"decimals" bson:"decimals"`
+	// ETHPrice and USDPrice are the rates of one token when the event happened, nil if unknown.
+	ETHPrice *Decimal `json:"ethPrice,omitempty" bson:"ethPrice,omitempty"`
+	USDPrice *Decimal `json:"usdPrice,omitempty" bson:"usdPrice,omitempty"`
+}
+
+var (
+	// etherTokens are the payment tokens worth one ETH.
+	etherTokens = map[common.Address]bool{
+		{}: true, // ETH
+		common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"): true, // WETH
+	}
+	// stablecoins are the payment tokens worth one US dollar.
+	stablecoins = map[common.Address]bool{
+		common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"): true, // USDC
+		common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F"): true, // DAI
+	}
+)
+
+// setPrice sets the price of raw, the amount in the smallest unit of token, and its ETH and USD values.
+// The price is left nil if raw is not a number.
+func (r *Record) setPrice(raw string, token Token) {
+	d, err := decimal.NewFromString(raw)
+	if err != nil {
+		return
+	}
+	r.Price = NewDecimal(d.Shift(-int32(token.Decimals)))
+	r.Payment = token
+	r.value()
+}
+
+// value computes the ETH and USD values of the price by the rates of the payment token,
+// ETH and WETH are worth one ETH, USDC and DAI are worth one dollar even if the rates are unknown.
+func (r *Record) value() {
+	if r.Price == nil {
+		return
+	}
+	eth, usd := r.Payment.ETHPrice, r.Payment.USDPrice
+	if r.Payment.Address != "" {
+		address := common.HexToAddress(r.Payment.Address)
+		if eth == nil && etherTokens[address] {
+			eth = NewDecimal(decimal.New(1, 0))
+		}
+		if usd == nil && stablecoins[address] {
+			usd = NewDecimal(decimal.New(1, 0))
+		}
+	}
+	if eth != nil {
+		r.ETH = NewDecimal(r.Price.Mul(eth.Decimal))
+	}
+	if usd != nil {
+		r.USD = NewDecimal(r.Price.Mul(usd.Decimal))
+	}
+}
+
+// rateBook keeps the latest rates of payment tokens reported by OpenSea,
+// the events of other sources (chain, LooksRare) are valued by them.
+type rateBook struct {
+	mu    sync.Mutex
+	rates map[string]Token // by token address
+}
+
+func newRateBook() *rateBook {
+	return &rateBook{rates: make(map[string]Token)}
+}
+
+// update learns the rates of the records, and values the records without rates by the learned ones.
+func (b *rateBook) update(records []Record) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, r := range records {
+		if r.Payment.Address != "" && r.Payment.ETHPrice != nil && r.Payment.USDPrice != nil {
+			b.rates[r.Payment.Address] = r.Payment
+		}
+	}
+	for i := range records {
+		r := &records[i]
+		if r.Price == nil || (r.Payment.ETHPrice != nil && r.Payment.USDPrice != nil) {
+			continue
+		}
+		known, ok := b.rates[r.Payment.Address]
+		if !ok {
+			continue
+		}
+		if r.Payment.ETHPrice == nil {
+			r.Payment.ETHPrice = known.ETHPrice
+		}
+		if r.Payment.USDPrice == nil {
+			r.Payment.USDPrice = known.USDPrice
+		}
+		r.value()
+	}
+}
@@ -0,0 +1,126 @@
+package opensea
+
+import (
+	"github.com/shopspring/decimal"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"testing"
+)
+
+func TestDecimalBSON(t *testing.T) {
+	in := Record{Event: EventSale, Price: NewDecimal(decimal.RequireFromString("0.000000000000000001"))}
+	data, err := bson.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := bson.Raw(data).Lookup("price").Type; typ != bsontype.Decimal128 {
+		t.Errorf("price saved as %s", typ)
+	}
+	var out Record
+	if err = bson.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Price == nil || !out.Price.Equal(in.Price.Decimal) {
+		t.Errorf("price = %v", out.Price)
+	}
+	// no price
+	if data, err = bson.Marshal(Record{Event: EventMint}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bson.Raw(data).LookupErr("price"); err == nil {
+		t.Error("nil price saved")
+	}
+
+	// saved before Decimal
+	data, err = bson.Marshal(bson.M{"event": EventList, "price": "1.5 ETH"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bson.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Price == nil || out.Price.String() != "1.5" {
+		t.Errorf("text price = %v", out.Price)
+	}
+	// Mint, Transfer and Digest saved the empty text price
+	data, err = bson.Marshal(bson.D{{"event", EventMint}, {"id", "7"}, {"price", ""}, {"quantity", int64(1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = Record{}
+	if err = bson.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Price != nil || out.Id != "7" || out.Quantity != 1 {
+		t.Errorf("empty text price = %+v", out)
+	}
+	if data, err = bson.Marshal(out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bson.Raw(data).LookupErr("price"); err == nil {
+		t.Error("empty text price saved again")
+	}
+}
+
+func TestRecordValue(t *testing.T) {
+	rate := func(s string) *Decimal {
+		return NewDecimal(decimal.RequireFromString(s))
+	}
+	weth := "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+	usdc := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	tests := []struct {
+		raw   string
+		token Token
+		eth   string
+		usd   string
+	}{
+		{"1500000000000000000", Token{Symbol: "WETH", Address: weth, Decimals: 18}, "1.5", ""},
+		{"1500000000000000000", Token{Symbol: "WETH", Address: weth, Decimals: 18,
+			ETHPrice: rate("1"), USDPrice: rate("3000")}, "1.5", "4500"},
+		{"3000000", Token{Symbol: "USDC", Address: usdc, Decimals: 6}, "", "3"},
+		{"3000000", Token{Symbol: "USDC", Address: usdc, Decimals: 6,
+			ETHPrice: rate("0.0005"), USDPrice: rate("1.001")}, "0.0015", "3.003"},
+		{"1000", Token{Symbol: "0x1234...5678", Address: "0x1234000000000000000000000000000000005678"}, "", ""},
+	}
+	for i, tt := range tests {
+		var r Record
+		r.setPrice(tt.raw, tt.token)
+		eth, usd := "", ""
+		if r.ETH != nil {
+			eth = r.ETH.String()
+		}
+		if r.USD != nil {
+			usd = r.USD.String()
+		}
+		if eth != tt.eth || usd != tt.usd {
+			t.Errorf("%d: eth = %q, usd = %q, want %q, %q", i, eth, usd, tt.eth, tt.usd)
+		}
+	}
+}
+
+func TestRateBook(t *testing.T) {
+	book := newRateBook()
+	weth := Token{Symbol: "WETH", Address: "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", Decimals: 18}
+	// from chain, no rates
+	var sale Record
+	sale.setPrice("2000000000000000000", weth)
+	records := []Record{sale}
+	book.update(records)
+	if records[0].USD != nil {
+		t.Errorf("usd = %s before rates known", records[0].USD)
+	}
+	// from OpenSea
+	reported := weth
+	reported.ETHPrice = NewDecimal(decimal.New(1, 0))
+	reported.USDPrice = NewDecimal(decimal.New(2500, 0))
+	var list Record
+	list.setPrice("1000000000000000000", reported)
+	records = []Record{list, sale}
+	book.update(records)
+	if records[1].USD == nil || records[1].USD.String() != "5000" || records[1].ETH.String() != "2" {
+		t.Errorf("valued sale = %v, %v", records[1].ETH, records[1].USD)
+	}
+	if got := valueText(records[1]); got != "2 ETH / $5000.00" {
+		t.Errorf("value text = %s", got)
+	}
+}
@@ -0,0 +1,362 @@
+// Package api is a typed client of the OpenSea HTTP API,
+// shared by the event monitor and the collection updater.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBaseURL   = "https://api.opensea.io"
+	DefaultTimeout   = time.Second * 30
+	DefaultUserAgent = "opensea-monitor"
+
+	DefaultRateLimit  = 2 // requests per second
+	DefaultBurst      = 2
+	DefaultMaxRetries = 3
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = time.Second * 30
+
+	AssetsLimit = 50 // max assets of one page
+
+	headerAPIKey = "X-API-KEY"
+)
+
+// Config is the `api` section of the config file.
+// All fields are optional, the empty value means the default one.
+type Config struct {
+	BaseURL   string `json:"baseUrl"`   // e.g. https://api.opensea.io, or a local mock server
+	Key       string `json:"key"`       // sent as X-API-KEY header
+	KeyHeader string `json:"keyHeader"` // header of Key for other APIs, default X-API-KEY
+	Timeout   string `json:"timeout"`   // timeout of one HTTP request, e.g. "30s"
+	UserAgent string `json:"userAgent"` // User-Agent header
+
+	RateLimit  float64 `json:"rateLimit"`  // requests per second of all polls together
+	Burst      int     `json:"burst"`      // max requests sent at once
+	MaxRetries int     `json:"maxRetries"` // retries on 429, 5xx and network error, negative means no retry
+	MinBackoff string  `json:"minBackoff"` // first backoff, doubled on every retry
+	MaxBackoff string  `json:"maxBackoff"` // backoff upper bound
+}
+
+type Client struct {
+	baseURL   string
+	key       string
+	keyHeader string
+	userAgent string
+
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	http    *http.Client
+	limiter *Limiter
+}
+
+func New(cfg Config) (*Client, error) {
+	c := &Client{
+		baseURL:   strings.TrimRight(cfg.BaseURL, "/"),
+		key:       cfg.Key,
+		keyHeader: cfg.KeyHeader,
+		userAgent: cfg.UserAgent,
+	}
+	if c.keyHeader == "" {
+		c.keyHeader = headerAPIKey
+	}
+	if c.baseURL == "" {
+		c.baseURL = DefaultBaseURL
+	}
+	if _, err := url.Parse(c.baseURL); err != nil {
+		return nil, fmt.Errorf("api base url %s format error: %w", c.baseURL, err)
+	}
+	if c.userAgent == "" {
+		c.userAgent = DefaultUserAgent
+	}
+	timeout, err := parseDuration("timeout", cfg.Timeout, DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.http = &http.Client{Timeout: timeout}
+	if c.minBackoff, err = parseDuration("minBackoff", cfg.MinBackoff, DefaultMinBackoff); err != nil {
+		return nil, err
+	}
+	if c.maxBackoff, err = parseDuration("maxBackoff", cfg.MaxBackoff, DefaultMaxBackoff); err != nil {
+		return nil, err
+	}
+	if c.maxBackoff < c.minBackoff {
+		c.maxBackoff = c.minBackoff
+	}
+	c.maxRetries = cfg.MaxRetries
+	if c.maxRetries == 0 {
+		c.maxRetries = DefaultMaxRetries
+	} else if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+	rate, burst := cfg.RateLimit, cfg.Burst
+	if rate <= 0 {
+		rate = DefaultRateLimit
+	}
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+	c.limiter = NewLimiter(rate, burst)
+	return c, nil
+}
+
+func parseDuration(name, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("api %s %s format error: %w", name, value, err)
+	}
+	return d, nil
+}
+
+// BaseURL returns the API endpoint the client talks to.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// EventsQuery is the query of `/api/v1/events`.
+// Zero fields are not sent.
+type EventsQuery struct {
+	CollectionSlug       string // all contracts of the collection
+	AssetContractAddress string
+	TokenId              string // only with AssetContractAddress
+	AccountAddress       string // events of the account, as maker, taker, sender or receiver
+	EventType            string // one of EventType*, empty means all
+	OccurredAfter        time.Time
+	OccurredBefore       time.Time
+	Cursor               string // `next` of the last page, Offset is ignored by the API when set
+	Offset               int
+	Limit                int
+}
+
+// String is the filter of the query for logging, without window and page.
+func (q EventsQuery) String() string {
+	var filters []string
+	if q.CollectionSlug != "" {
+		filters = append(filters, "collection="+q.CollectionSlug)
+	}
+	if q.AssetContractAddress != "" {
+		filters = append(filters, "contract="+q.AssetContractAddress)
+	}
+	if q.TokenId != "" {
+		filters = append(filters, "token="+q.TokenId)
+	}
+	if q.AccountAddress != "" {
+		filters = append(filters, "account="+q.AccountAddress)
+	}
+	if q.EventType != "" {
+		filters = append(filters, "type="+q.EventType)
+	}
+	return "{" + strings.Join(filters, ", ") + "}"
+}
+
+func (q EventsQuery) values() url.Values {
+	v := url.Values{}
+	if q.CollectionSlug != "" {
+		v.Set("collection_slug", q.CollectionSlug)
+	}
+	if q.AssetContractAddress != "" {
+		v.Set("asset_contract_address", q.AssetContractAddress)
+	}
+	if q.TokenId != "" {
+		v.Set("token_id", q.TokenId)
+	}
+	if q.AccountAddress != "" {
+		v.Set("account_address", q.AccountAddress)
+	}
+	if q.EventType != "" {
+		v.Set("event_type", q.EventType)
+	}
+	v.Set("only_opensea", "false")
+	if !q.OccurredAfter.IsZero() {
+		v.Set("occurred_after", strconv.FormatInt(q.OccurredAfter.Unix(), 10))
+	}
+	if !q.OccurredBefore.IsZero() {
+		v.Set("occurred_before", strconv.FormatInt(q.OccurredBefore.Unix(), 10))
+	}
+	if q.Cursor != "" {
+		v.Set("cursor", q.Cursor)
+	} else {
+		v.Set("offset", strconv.Itoa(q.Offset))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// Events call `/api/v1/events`.
+// Request like this:
+//
+//	curl --request GET \
+//	    --url 'https://api.opensea.io/api/v1/events?asset_contract_address=0x...&only_opensea=false&offset=0&limit=300'
+func (c *Client) Events(ctx context.Context, q EventsQuery) (*ResponseEvent, error) {
+	var r ResponseEvent
+	if err := c.Get(ctx, "/api/v1/events", q.values(), &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Collections call `/api/v1/collections`.
+// Request like this:
+//
+//	curl --request GET \
+//	    --url 'https://api.opensea.io/api/v1/collections?offset=0&limit=300'
+func (c *Client) Collections(ctx context.Context, offset, limit int) (*ResponseCollections, error) {
+	v := url.Values{}
+	v.Set("offset", strconv.Itoa(offset))
+	v.Set("limit", strconv.Itoa(limit))
+	var r ResponseCollections
+	if err := c.Get(ctx, "/api/v1/collections", v, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// CollectionStats call `/api/v1/collection/{slug}/stats`.
+// Request like this:
+//
+//	curl --request GET \
+//	    --url 'https://api.opensea.io/api/v1/collection/doodles-official/stats'
+func (c *Client) CollectionStats(ctx context.Context, slug string) (*RawStat, error) {
+	var r ResponseStats
+	if err := c.Get(ctx, "/api/v1/collection/"+url.PathEscape(slug)+"/stats", nil, &r); err != nil {
+		return nil, err
+	}
+	return &r.Stats, nil
+}
+
+// Assets call `/api/v1/assets` for one page of the assets of the collection, with their traits.
+// Request like this:
+//
+//	curl --request GET \
+//	    --url 'https://api.opensea.io/api/v1/assets?collection=doodles-official&limit=50'
+func (c *Client) Assets(ctx context.Context, slug, cursor string) (*ResponseAssets, error) {
+	v := url.Values{}
+	v.Set("collection", slug)
+	v.Set("limit", strconv.Itoa(AssetsLimit))
+	if cursor != "" {
+		v.Set("cursor", cursor)
+	}
+	var r ResponseAssets
+	if err := c.Get(ctx, "/api/v1/assets", v, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Asset call `/api/v1/asset/{contract}/{token_id}/`, the asset has its traits.
+// Request like this:
+//
+//	curl --request GET \
+//	    --url 'https://api.opensea.io/api/v1/asset/0xb47e3cd837ddf8e4c57f05d70ab865de6e193bbb/1/'
+func (c *Client) Asset(ctx context.Context, contract, tokenId string) (*Asset, error) {
+	var r Asset
+	if err := c.Get(ctx, "/api/v1/asset/"+url.PathEscape(contract)+"/"+url.PathEscape(tokenId)+"/", nil, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// URL returns the full request url of path and query, it's also used for logging.
+func (c *Client) URL(path string, query url.Values) string {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// Get sends the request through the shared limiter, retries on 429, 5xx and network error
+// with exponential backoff, and decodes the 200 response into v.
+// A non-200 response is returned as *StatusError.
+// It's also used by the clients of other marketplace APIs with their own Config.
+func (c *Client) Get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := c.URL(path, query)
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		err := c.do(ctx, u, v)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var wait time.Duration
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			if !statusErr.Temporary() {
+				return err
+			}
+			if statusErr.RetryAfter > 0 {
+				c.limiter.Block(statusErr.RetryAfter)
+				wait = statusErr.RetryAfter
+			}
+		} else if errors.Is(err, errDecode) {
+			return err
+		}
+		if attempt >= c.maxRetries {
+			return err
+		}
+		if b := Backoff(attempt, c.minBackoff, c.maxBackoff); b > wait {
+			wait = b
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+var errDecode = errors.New("decode response error")
+
+func (c *Client) do(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if c.key != "" {
+		req.Header.Set(c.keyHeader, c.key)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+		return &StatusError{
+			URL:        u,
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			Body:       string(body),
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w of %s: %s", errDecode, u, err)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/events" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get(headerAPIKey); got != "secret" {
+			t.Errorf("api key = %s", got)
+		}
+		if got := r.Header.Get("User-Agent"); got != "monitor-test" {
+			t.Errorf("user agent = %s", got)
+		}
+		q := r.URL.Query()
+		if q.Get("asset_contract_address") != "0xabc" || q.Get("occurred_after") != "100" ||
+			q.Get("occurred_before") != "200" || q.Get("limit") != "300" {
+			t.Errorf("query = %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(`{"asset_events":[{"event_type":"successful","total_price":"1000"}]}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{BaseURL: srv.URL + "/", Key: "secret", UserAgent: "monitor-test", Timeout: "5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Events(context.Background(), EventsQuery{
+		AssetContractAddress: "0xabc",
+		OccurredAfter:        time.Unix(100, 0),
+		OccurredBefore:       time.Unix(200, 0),
+		Limit:                300,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.AssetEvents) != 1 || r.AssetEvents[0].EventType != EventTypeSale {
+		t.Errorf("events = %+v", r.AssetEvents)
+	}
+}
+
+func TestClientCollectionStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/collection/cool-cats/stats" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{"stats":{"floor_price":1.25,"total_supply":9999}}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := c.CollectionStats(context.Background(), "cool-cats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.FloorPrice != 1.25 || stats.TotalSupply != 9999 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestClientAsset(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/asset/0xabc/7/" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{"token_id":"7","traits":[{"trait_type":"Background","value":"Gold"},{"trait_type":"Level","value":3}]}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset, err := c.Asset(context.Background(), "0xabc", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asset.Traits) != 2 || asset.Traits[0].TraitType != "Background" || asset.Traits[1].Value != float64(3) {
+		t.Errorf("asset = %+v", asset)
+	}
+}
+
+func TestClientAssets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v1/assets" || q.Get("collection") != "cool-cats" || q.Get("limit") != "50" {
+			t.Errorf("request = %s", r.URL)
+		}
+		if q.Get("cursor") == "" {
+			_, _ = w.Write([]byte(`{"assets":[{"token_id":"1","traits":[{"trait_type":"Eyes","value":"Blue"}]}],"next":"p2"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"assets":[{"token_id":"2"}],"next":null}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Assets(context.Background(), "cool-cats", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Assets) != 1 || len(r.Assets[0].Traits) != 1 || r.NextCursor() != "p2" {
+		t.Errorf("first page = %+v", r)
+	}
+	if r, err = c.Assets(context.Background(), "cool-cats", r.NextCursor()); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Assets) != 1 || r.Assets[0].TokenId != "2" || r.NextCursor() != "" {
+		t.Errorf("last page = %+v", r)
+	}
+}
+
+func TestEventsQueryValues(t *testing.T) {
+	tests := []struct {
+		q    EventsQuery
+		want string
+	}{
+		{EventsQuery{CollectionSlug: "cool-cats", EventType: EventTypeSale},
+			"collection_slug=cool-cats&event_type=successful&offset=0&only_opensea=false"},
+		{EventsQuery{AssetContractAddress: "0xabc", TokenId: "7", Offset: 300},
+			"asset_contract_address=0xabc&offset=300&only_opensea=false&token_id=7"},
+		{EventsQuery{AccountAddress: "0xdef", Cursor: "next", Offset: 300},
+			"account_address=0xdef&cursor=next&only_opensea=false"},
+	}
+	for i, tt := range tests {
+		if got := tt.q.values().Encode(); got != tt.want {
+			t.Errorf("%d: query = %s, want %s", i, got, tt.want)
+		}
+	}
+}
+
+func TestNewDefault(t *testing.T) {
+	c, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BaseURL() != DefaultBaseURL || c.userAgent != DefaultUserAgent || c.http.Timeout != DefaultTimeout {
+		t.Errorf("default client = %+v", c)
+	}
+	if _, err = New(Config{Timeout: "ten seconds"}); err == nil {
+		t.Error("bad timeout should be error")
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			_, _ = w.Write([]byte(`{"collections":[{"name":"Cool Cats"}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{BaseURL: srv.URL, RateLimit: 1000, MinBackoff: "1ms", MaxBackoff: "5ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Collections(context.Background(), 0, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || len(r.Collections) != 1 {
+		t.Errorf("calls = %d, collections = %+v", calls, r.Collections)
+	}
+}
+
+func TestClientStatusError(t *testing.T) {
+	tests := []struct {
+		status      int
+		wantCalls   int
+		rateLimited bool
+	}{
+		{http.StatusTooManyRequests, 3, true},
+		{http.StatusInternalServerError, 3, false},
+		{http.StatusNotFound, 1, false},
+	}
+	for _, tt := range tests {
+		var calls int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(tt.status)
+		}))
+		c, err := New(Config{BaseURL: srv.URL, RateLimit: 1000, MaxRetries: 2, MinBackoff: "1ms", MaxBackoff: "5ms"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.Events(context.Background(), EventsQuery{})
+		srv.Close()
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status {
+			t.Errorf("status %d: err = %v", tt.status, err)
+		}
+		if errors.Is(err, ErrRateLimited) != tt.rateLimited {
+			t.Errorf("status %d: rate limited = %v", tt.status, !tt.rateLimited)
+		}
+		if calls != tt.wantCalls {
+			t.Errorf("status %d: calls = %d, want %d", tt.status, calls, tt.wantCalls)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"7", 7 * time.Second},
+		{"-1", 0},
+		{"Wed, 01 Sep 2021 00:00:30 GMT", 30 * time.Second},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		d := Backoff(attempt, time.Second, time.Second*8)
+		if d < time.Second/2 || d >= time.Second*8 {
+			t.Errorf("Backoff(%d) = %s", attempt, d)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(10, 2)
+	now := time.Now()
+	if l.reserve(now) != 0 || l.reserve(now) != 0 {
+		t.Fatal("burst tokens should be available")
+	}
+	if wait := l.reserve(now); wait <= 0 || wait > 100*time.Millisecond {
+		t.Errorf("wait = %s", wait)
+	}
+	if l.reserve(now.Add(100*time.Millisecond)) != 0 {
+		t.Error("token should be refilled")
+	}
+	l.Block(time.Hour)
+	if wait := l.reserve(time.Now()); wait < 59*time.Minute {
+		t.Errorf("blocked wait = %s", wait)
+	}
+	// refilled from the end of the block, not a full burst
+	end := l.until.Add(100 * time.Millisecond)
+	if l.reserve(end) != 0 {
+		t.Error("one token should be refilled after the block")
+	}
+	if wait := l.reserve(end); wait <= 0 {
+		t.Error("only one token should be available after the block")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait error = %v", err)
+	}
+}
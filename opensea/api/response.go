@@ -1,4 +1,4 @@
-package opensea
+package api
 
 import (
 	"fmt"
@@ -8,9 +8,21 @@ import (
 type ResponseEvent struct {
 	Success     *bool
 	AssetEvents []AssetEvent `json:"asset_events"`
+	// Next and Previous are the cursors of pagination, empty or null if no more page.
+	Next     *string `json:"next"`
+	Previous *string `json:"previous"`
+}
+
+// NextCursor returns the cursor of next page, or "" if the response has no next page.
+func (r *ResponseEvent) NextCursor() string {
+	if r.Next == nil {
+		return ""
+	}
+	return *r.Next
 }
 
 type AssetEvent struct {
+	Id    int64 `json:"id"` // unique event id, used for de-duplication
 	Asset Asset `json:"asset"`
 	// transfer: Mint或者是真实的Transfer
 	// created: List
@@ -29,14 +41,18 @@ type AssetEvent struct {
 	// used when EventType = `successful` or `bid_withdrawn`, means sale or cancel offer
 	TotalPrice string `json:"total_price"`
 
-	CreatedDate   string   `json:"created_date"`
-	FromAccount   *Account `json:"from_account"`
-	ToAccount     *Account `json:"to_account"`
-	Owner         *Account `json:"owner"`
-	Seller        *Account `json:"seller"`
-	WinnerAccount *Account `json:"winner_account"`
+	Quantity string `json:"quantity"` // number of tokens, empty for 1
+
+	CreatedDate    string   `json:"created_date"`
+	EventTimestamp string   `json:"event_timestamp"` // when the event occurred, used by occurred_after/occurred_before
+	FromAccount    *Account `json:"from_account"`
+	ToAccount      *Account `json:"to_account"`
+	Owner          *Account `json:"owner"`
+	Seller         *Account `json:"seller"`
+	WinnerAccount  *Account `json:"winner_account"`
 
 	PaymentToken PaymentToken `json:"payment_token"`
+	Transaction  *Transaction `json:"transaction"` // nil for off-chain events, e.g. List and Bid
 }
 
 const (
@@ -44,6 +60,7 @@ const (
 	EventTypeList      = "created"
 	EventTypeBid       = "bid_entered"
 	EventTypeBidCancel = "bid_withdrawn"
+	EventTypeCancel    = "cancelled" // listing cancelled
 	EventTypeSale      = "successful"
 	EventTypeOffer     = "offer_entered"
 )
@@ -54,7 +71,13 @@ type Asset struct {
 	AssetContract AssetContract   `json:"asset_contract"`
 	Collection    AssetCollection `json:"collection"`
 
-	ImagePreviewUrl string `json:"image_preview_url"`
+	ImagePreviewUrl string  `json:"image_preview_url"`
+	Traits          []Trait `json:"traits"` // only in the asset and assets API
+}
+
+type Trait struct {
+	TraitType string      `json:"trait_type"`
+	Value     interface{} `json:"value"` // string or number
 }
 
 type AssetContract struct {
@@ -64,6 +87,7 @@ type AssetContract struct {
 
 type AssetCollection struct {
 	Name string `json:"name"`
+	Slug string `json:"slug"`
 }
 
 type Account struct {
@@ -82,13 +106,21 @@ func (a Account) String() string {
 	}
 }
 
+type Transaction struct {
+	TransactionHash string `json:"transaction_hash"`
+	BlockNumber     string `json:"block_number"`
+}
+
 type User struct {
 	Username string `json:"username"`
 }
 
 type PaymentToken struct {
 	Symbol   string `json:"symbol"`
+	Address  string `json:"address"`
 	Decimals int    `json:"decimals"`
+	EthPrice string `json:"eth_price"` // rate in ETH, e.g. "1.000000000000000"
+	UsdPrice string `json:"usd_price"` // rate in USD, e.g. "3012.310000000000000"
 }
 
 // ResponseCollections is response of `/collection` API
@@ -100,12 +132,32 @@ type ResponseCollections struct {
 // It's different from the ResponseEvent.
 type RawCollection struct {
 	Name                  string
+	Slug                  string `json:"slug"`
 	Description           string
 	PrimaryAssetContracts []RawAssetContract `json:"primary_asset_contracts"`
 	Stats                 RawStat            `json:"stats"`
 	CreatedDate           string             `json:"created_date"`
 }
 
+// ResponseAssets is response of `/assets` API.
+type ResponseAssets struct {
+	Assets []Asset `json:"assets"`
+	Next   *string `json:"next"`
+}
+
+// NextCursor returns the cursor of next page, or "" if the response has no next page.
+func (r *ResponseAssets) NextCursor() string {
+	if r.Next == nil {
+		return ""
+	}
+	return *r.Next
+}
+
+// ResponseStats is response of `/collection/{slug}/stats` API.
+type ResponseStats struct {
+	Stats RawStat `json:"stats"`
+}
+
 type RawStat struct {
 	OneDayVolume       float64 `json:"one_day_volume" bson:"oneDayVolume"`
 	OneDayChange       float64 `json:"one_day_change" bson:"oneDayChange"`
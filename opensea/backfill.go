@@ -0,0 +1,210 @@
+package opensea
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/xyths/hs"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// CollHistory keeps the historical events downloaded by backfill, they never expire.
+	CollHistory    = "history"
+	collCheckpoint = "checkpoints"
+
+	historyEventIdIndexName = "historyEventIdIndex"
+
+	defaultBackfillStep = time.Hour
+)
+
+// BackfillConfig is the part of the config file used by backfill, it needs no bot.
+type BackfillConfig struct {
+	Mongo hs.MongoConf
+	Log   hs.LogConf
+	API   api.Config `json:"api"`
+}
+
+// Backfill downloads historical events of one contract into MongoDB, it never sends messages.
+type Backfill struct {
+	cfg BackfillConfig
+
+	Sugar  *zap.SugaredLogger
+	db     *mongo.Database
+	client *api.Client
+}
+
+// Checkpoint is the progress of one backfill task, the task is identified by contract and from,
+// so running again with a later `to` continues the same task.
+type Checkpoint struct {
+	Contract     string    `bson:"contract"`
+	From         time.Time `bson:"from"`
+	To           time.Time `bson:"to"`
+	Done         time.Time `bson:"done"` // events in [From, Done) are saved
+	Count        int       `bson:"count"`
+	LastModified time.Time `bson:"lastModified"`
+}
+
+func NewBackfill(cfg BackfillConfig) *Backfill {
+	return &Backfill{cfg: cfg}
+}
+
+func (b *Backfill) Init(ctx context.Context) error {
+	l, err := hs.NewZapLogger(b.cfg.Log)
+	if err != nil {
+		return err
+	}
+	b.Sugar = l.Sugar()
+	b.Sugar.Info("logger initialized")
+	db, err := hs.ConnectMongo(ctx, b.cfg.Mongo)
+	if err != nil {
+		b.Sugar.Errorf("connect mongo error: %s", err)
+		return err
+	}
+	b.db = db
+	if err = b.initIndex(ctx); err != nil {
+		b.Sugar.Errorf("init index error: %s", err)
+		return err
+	}
+	b.Sugar.Info("database initialized")
+	b.client, err = api.New(b.cfg.API)
+	if err != nil {
+		b.Sugar.Errorf("OpenSea API client init error: %s", err)
+		return err
+	}
+	b.Sugar.Infof("OpenSea API client initialized, endpoint: %s", b.client.BaseURL())
+	b.Sugar.Info("Backfill initialized")
+	return nil
+}
+
+func (b *Backfill) Close(ctx context.Context) {
+	if err := b.db.Client().Disconnect(ctx); err != nil {
+		b.Sugar.Errorf("db close error: %s", err)
+	}
+	b.Sugar.Info("Backfill closed")
+}
+
+// Run downloads events of the contract in [from, to), `step` by `step`.
+// The checkpoint is saved after every step, so an interrupted task resumes from the last step.
+func (b *Backfill) Run(ctx context.Context, contract string, from, to time.Time, step time.Duration) error {
+	if !from.Before(to) {
+		return fmt.Errorf("from %s should be before to %s", from, to)
+	}
+	if step <= 0 {
+		step = defaultBackfillStep
+	}
+	contract = common.HexToAddress(contract).Hex()
+	cp, err := b.loadCheckpoint(ctx, contract, from, to)
+	if err != nil {
+		b.Sugar.Errorf("load checkpoint error: %s", err)
+		return err
+	}
+	if cp.Done.After(from) {
+		b.Sugar.Infof("resume backfill of %s from %s, %d events saved", contract, cp.Done, cp.Count)
+	}
+	for start := cp.Done; start.Before(to); start = cp.Done {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		end := start.Add(step)
+		if end.After(to) {
+			end = to
+		}
+		records, err := fetchEvents(ctx, b.client, b.Sugar, api.EventsQuery{AssetContractAddress: contract}, start, end)
+		if err != nil {
+			b.Sugar.Errorf("fetch events of %s in %s - %s error: %s", contract, start, end, err)
+			return err
+		}
+		if err = b.saveHistory(ctx, records); err != nil {
+			b.Sugar.Errorf("save events error: %s", err)
+			return err
+		}
+		cp.Done = end
+		cp.Count += len(records)
+		if err = b.saveCheckpoint(ctx, cp); err != nil {
+			b.Sugar.Errorf("save checkpoint error: %s", err)
+			return err
+		}
+		b.Sugar.Infof("backfill %s: %d events in %s - %s, total %d", contract, len(records), start, end, cp.Count)
+	}
+	b.Sugar.Infof("backfill %s finished, %d events in %s - %s", contract, cp.Count, from, to)
+	return nil
+}
+
+func (b *Backfill) initIndex(ctx context.Context) error {
+	coll := b.db.Collection(CollHistory)
+	index := mongo.IndexModel{
+		Keys: bson.D{{"eventId", 1}},
+		Options: options.Index().SetUnique(true).SetName(historyEventIdIndexName).
+			SetPartialFilterExpression(bson.D{{"eventId", bson.D{{"$gt", ""}}}}),
+	}
+	_, err := coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+// saveHistory saves records, the records already saved (same event id) are replaced,
+// so a step can be downloaded again safely.
+func (b *Backfill) saveHistory(ctx context.Context, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(records))
+	for _, r := range records {
+		if r.EventId == "" {
+			models = append(models, mongo.NewInsertOneModel().SetDocument(r))
+			continue
+		}
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{"eventId", r.EventId}}).
+			SetReplacement(r).
+			SetUpsert(true))
+	}
+	coll := b.db.Collection(CollHistory)
+	_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (b *Backfill) loadCheckpoint(ctx context.Context, contract string, from, to time.Time) (Checkpoint, error) {
+	coll := b.db.Collection(collCheckpoint)
+	cp := Checkpoint{Contract: contract, From: from, To: to, Done: from}
+	err := coll.FindOne(ctx, bson.D{
+		{"contract", contract},
+		{"from", from},
+	}).Decode(&cp)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return cp, err
+	}
+	cp.To = to
+	return cp, nil
+}
+
+func (b *Backfill) saveCheckpoint(ctx context.Context, cp Checkpoint) error {
+	coll := b.db.Collection(collCheckpoint)
+	_, err := coll.UpdateOne(
+		ctx,
+		bson.D{
+			{"contract", cp.Contract},
+			{"from", cp.From},
+		},
+		bson.D{
+			{"$set", bson.D{
+				{"to", cp.To},
+				{"done", cp.Done},
+				{"count", cp.Count},
+			}},
+			{"$currentDate", bson.D{
+				{"lastModified", true},
+			}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
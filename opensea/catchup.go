@@ -0,0 +1,146 @@
+package opensea
+
+import (
+	"context"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// CatchUpAll delivers every missed event to chats, like live events.
+	CatchUpAll = "all"
+	// CatchUpDigest delivers one summary message of the missed period to every chat.
+	CatchUpDigest = "digest"
+	// CatchUpNone only stores missed events, nothing is sent.
+	CatchUpNone = "none"
+
+	defaultMaxDelay = time.Minute * 15
+	defaultMaxGap   = time.Hour * 24
+)
+
+// CatchUpConf is the policy after downtime.
+// When a project's watermark is older than MaxDelay, the whole gap (at most MaxGap)
+// is fetched and saved into the events store, and delivered according to Deliver.
+type CatchUpConf struct {
+	MaxDelay string `json:"maxDelay"` // default 15m
+	MaxGap   string `json:"maxGap"`   // default 24h
+	Deliver  string `json:"deliver"`  // all, digest or none, default digest
+}
+
+type catchUpPolicy struct {
+	maxDelay time.Duration
+	maxGap   time.Duration
+	deliver  string
+}
+
+func parseCatchUp(conf CatchUpConf) (p catchUpPolicy, err error) {
+	p.maxDelay, p.maxGap, p.deliver = defaultMaxDelay, defaultMaxGap, CatchUpDigest
+	if conf.MaxDelay != "" {
+		if p.maxDelay, err = time.ParseDuration(conf.MaxDelay); err != nil {
+			return p, fmt.Errorf("catchUp maxDelay %s format error: %w", conf.MaxDelay, err)
+		}
+	}
+	if conf.MaxGap != "" {
+		if p.maxGap, err = time.ParseDuration(conf.MaxGap); err != nil {
+			return p, fmt.Errorf("catchUp maxGap %s format error: %w", conf.MaxGap, err)
+		}
+	}
+	if p.maxGap < p.maxDelay {
+		p.maxGap = p.maxDelay
+	}
+	switch conf.Deliver {
+	case "":
+	case CatchUpAll, CatchUpDigest, CatchUpNone:
+		p.deliver = conf.Deliver
+	default:
+		return p, fmt.Errorf("catchUp deliver %s is unknown, should be %s, %s or %s",
+			conf.Deliver, CatchUpAll, CatchUpDigest, CatchUpNone)
+	}
+	return p, nil
+}
+
+// catchUp accumulates the missed events of one poll round.
+type catchUp struct {
+	batch    string // id of the round, records of the round have it in Record.CatchUp
+	from, to time.Time
+	records  []Record
+}
+
+func (c *catchUp) add(from, to time.Time, records []Record) {
+	if c.from.IsZero() || from.Before(c.from) {
+		c.from = from
+	}
+	if to.After(c.to) {
+		c.to = to
+	}
+	c.records = append(c.records, records...)
+}
+
+// saveDigest saves the digest marker of the batch after all its records,
+// bots send every chat one summary of the batch records when reading the marker.
+func (s *OpenSea) saveDigest(ctx context.Context, c *catchUp) error {
+	marker := Record{
+		Event:     EventDigest,
+		CatchUp:   c.batch,
+		Digest:    &DigestWindow{From: c.from, To: c.to},
+		CreatedAt: time.Now(),
+	}
+	_, err := s.db.Collection(CollEvent).InsertOne(ctx, marker)
+	return err
+}
+
+// Digest is the summary of missed events, for Telegram text message.
+func Digest(records []Record, from, to time.Time) string {
+	const layout = "01-02 15:04"
+	content := fmt.Sprintf("离线期间 (%s - %s) 共 %d 个事件",
+		from.Local().Format(layout), to.Local().Format(layout), len(records))
+
+	counts := make(map[string]int)
+	var top *Record
+	var topPrice decimal.Decimal
+	for i, r := range records {
+		counts[r.Event]++
+		if r.Event != EventSale {
+			continue
+		}
+		// by ETH value if known, sales in different tokens are comparable
+		p := r.ETH
+		if p == nil {
+			p = r.Price
+		}
+		if p != nil && (top == nil || p.GreaterThan(topPrice)) {
+			top, topPrice = &records[i], p.Decimal
+		}
+	}
+	events := make([]string, 0, len(counts))
+	for e := range counts {
+		events = append(events, e)
+	}
+	sort.Strings(events)
+	for _, e := range events {
+		content += fmt.Sprintf("\n  %s: %d", e, counts[e])
+	}
+	if top != nil {
+		content += fmt.Sprintf("\n最高成交: %s %s #%s %s", top.Collection, top.Name, top.Id, priceText(*top))
+		if value := valueText(*top); value != "" {
+			content += fmt.Sprintf(" (%s)", value)
+		}
+	}
+	return content
+}
+
+// priceValue parses the amount of formatted price like "1.5 ETH".
+func priceValue(price string) (decimal.Decimal, bool) {
+	fields := strings.Fields(price)
+	if len(fields) == 0 {
+		return decimal.Zero, false
+	}
+	d, err := decimal.NewFromString(fields[0])
+	if err != nil {
+		return decimal.Zero, false
+	}
+	return d, true
+}
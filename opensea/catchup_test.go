@@ -0,0 +1,48 @@
+package opensea
+
+import (
+	"github.com/shopspring/decimal"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCatchUp(t *testing.T) {
+	p, err := parseCatchUp(CatchUpConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.maxDelay != defaultMaxDelay || p.maxGap != defaultMaxGap || p.deliver != CatchUpDigest {
+		t.Errorf("default policy = %+v", p)
+	}
+	p, err = parseCatchUp(CatchUpConf{MaxDelay: "1h", MaxGap: "10m", Deliver: CatchUpAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.maxGap != time.Hour || p.deliver != CatchUpAll {
+		t.Errorf("policy = %+v", p)
+	}
+	if _, err = parseCatchUp(CatchUpConf{Deliver: "some"}); err == nil {
+		t.Error("unknown deliver should be error")
+	}
+}
+
+func TestDigest(t *testing.T) {
+	price := func(amount string) *Decimal {
+		return NewDecimal(decimal.RequireFromString(amount))
+	}
+	eth, weth := Token{Symbol: "ETH", Decimals: 18}, Token{Symbol: "WETH", Decimals: 18}
+	records := []Record{
+		{Collection: "Cool Cats", Id: "1", Event: EventSale, Price: price("1.5"), Payment: eth},
+		{Collection: "Cool Cats", Id: "2", Event: EventSale, Price: price("12"), Payment: eth},
+		{Collection: "Cool Cats", Id: "3", Event: EventList, Price: price("20"), Payment: eth},
+		{Collection: "Cool Cats", Id: "4", Event: EventSale, Price: price("3.2"), Payment: weth},
+	}
+	from := time.Date(2021, 9, 1, 10, 0, 0, 0, time.Local)
+	content := Digest(records, from, from.Add(time.Hour))
+	for _, want := range []string{"09-01 10:00 - 09-01 11:00", "共 4 个事件", "Sale: 3", "List: 1", "#2 12 ETH"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("digest missing %q:\n%s", want, content)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package opensea
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"go.uber.org/zap"
+	"math/big"
+	"time"
+)
+
+const (
+	// SourceChain is the event source of transfer logs on chain.
+	SourceChain = "chain"
+
+	defaultConfirmations = 2
+	defaultMaxBlocks     = 2000
+)
+
+var (
+	topicTransfer       = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	topicTransferSingle = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	topicTransferBatch  = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+
+	// transferBatchData is the non-indexed `ids` and `values` of TransferBatch.
+	transferBatchData = func() abi.Arguments {
+		uint256s, _ := abi.NewType("uint256[]", "", nil)
+		return abi.Arguments{{Name: "ids", Type: uint256s}, {Name: "values", Type: uint256s}}
+	}()
+)
+
+// ChainConf enables the transfer logs of the monitored contracts and the sales of the marketplaces
+// from an Ethereum node, so mints and sales are alerted without the indexing delay of OpenSea.
+type ChainConf struct {
+	URL string `json:"url"` // node RPC url, empty to disable
+	// Contracts are watched besides the contracts of the projects.
+	Contracts []string `json:"contracts"`
+	// Marketplaces are the Seaport and Wyvern exchange contracts, default the ones of OpenSea.
+	Marketplaces []string `json:"marketplaces"`
+	// Confirmations is how many blocks behind the head are read, default 2.
+	Confirmations uint64 `json:"confirmations"`
+	// MaxBlocks is the max block range of one log query, default 2000.
+	MaxBlocks uint64 `json:"maxBlocks"`
+}
+
+// ChainReader is the part of ethclient.Client used by ChainSource,
+// the simulated backend of go-ethereum implements it too.
+type ChainReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// ChainSource reads ERC-721 `Transfer` and ERC-1155 `TransferSingle`/`TransferBatch` logs of contracts,
+// and converts them to Mint and Transfer records.
+// It also decodes Seaport `OrderFulfilled` and Wyvern `OrdersMatched` logs of the marketplaces to Sale records.
+type ChainSource struct {
+	client        ChainReader
+	confirmations uint64
+	maxBlocks     uint64
+	marketplaces  map[common.Address]bool
+
+	Sugar *zap.SugaredLogger
+}
+
+func NewChainSource(client ChainReader, conf ChainConf, sugar *zap.SugaredLogger) (*ChainSource, error) {
+	c := &ChainSource{
+		client:        client,
+		confirmations: conf.Confirmations,
+		maxBlocks:     conf.MaxBlocks,
+		marketplaces:  make(map[common.Address]bool),
+		Sugar:         sugar,
+	}
+	if c.confirmations == 0 {
+		c.confirmations = defaultConfirmations
+	}
+	if c.maxBlocks == 0 {
+		c.maxBlocks = defaultMaxBlocks
+	}
+	for _, a := range conf.Marketplaces {
+		if !common.IsHexAddress(a) {
+			return nil, fmt.Errorf("marketplace %s is not an address", a)
+		}
+		c.marketplaces[common.HexToAddress(a)] = true
+	}
+	if len(c.marketplaces) == 0 {
+		c.marketplaces[SeaportAddress] = true
+		c.marketplaces[WyvernAddress] = true
+	}
+	return c, nil
+}
+
+// Head returns the newest block with enough confirmations.
+func (c *ChainSource) Head(ctx context.Context) (uint64, error) {
+	header, err := c.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	head := header.Number.Uint64()
+	if head < c.confirmations {
+		return 0, nil
+	}
+	return head - c.confirmations, nil
+}
+
+// Fetch reads the transfer logs of the contracts and the sales of them on the marketplaces
+// in blocks [from, to], at most maxBlocks per query, records are in chain order.
+func (c *ChainSource) Fetch(ctx context.Context, contracts []common.Address, from, to uint64) ([]Record, error) {
+	var records []Record
+	watched := make(map[string]bool, len(contracts))
+	addresses := make([]common.Address, 0, len(contracts)+len(c.marketplaces))
+	for _, a := range contracts {
+		watched[a.Hex()] = true
+		addresses = append(addresses, a)
+	}
+	for a := range c.marketplaces {
+		addresses = append(addresses, a)
+	}
+	times := make(map[uint64]time.Time) // block time
+	receipts := make(map[common.Hash]*types.Receipt)
+	for start := from; start <= to; start += c.maxBlocks {
+		end := start + c.maxBlocks - 1
+		if end > to {
+			end = to
+		}
+		logs, err := c.client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(start),
+			ToBlock:   new(big.Int).SetUint64(end),
+			Addresses: addresses,
+			Topics: [][]common.Hash{{
+				topicTransfer, topicTransferSingle, topicTransferBatch,
+				topicOrderFulfilled, topicOrdersMatched,
+			}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("filter logs in blocks %d - %d error: %w", start, end, err)
+		}
+		c.Sugar.Debugf("%d logs in blocks %d - %d", len(logs), start, end)
+		for _, l := range logs {
+			if l.Removed {
+				continue
+			}
+			var transfers []Record
+			if c.marketplaces[l.Address] {
+				sales, err := c.decodeSale(ctx, l, receipts)
+				if err != nil {
+					return nil, err
+				}
+				for _, r := range sales {
+					if watched[r.Contract] {
+						transfers = append(transfers, r)
+					}
+				}
+			} else if transfers, err = decodeTransfer(l); err != nil {
+				c.Sugar.Warnf("decode log %s:%d error: %s", l.TxHash.Hex(), l.Index, err)
+				continue
+			}
+			if len(transfers) == 0 {
+				continue
+			}
+			t, ok := times[l.BlockNumber]
+			if !ok {
+				header, err := c.client.HeaderByNumber(ctx, new(big.Int).SetUint64(l.BlockNumber))
+				if err != nil {
+					return nil, fmt.Errorf("header of block %d error: %w", l.BlockNumber, err)
+				}
+				t = time.Unix(int64(header.Time), 0)
+				times[l.BlockNumber] = t
+			}
+			for _, r := range transfers {
+				r.Time = t
+				r.CreatedAt = time.Now()
+				records = append(records, r)
+			}
+		}
+		if end == to {
+			break
+		}
+	}
+	return records, nil
+}
+
+// decodeSale decodes a log of the marketplaces, the receipts are cached by transaction.
+// A log failed to decode is skipped, only the receipt request error is returned.
+func (c *ChainSource) decodeSale(ctx context.Context, l types.Log, receipts map[common.Hash]*types.Receipt) ([]Record, error) {
+	var sales []Record
+	var err error
+	switch l.Topics[0] {
+	case topicOrderFulfilled:
+		sales, err = decodeOrderFulfilled(l)
+	case topicOrdersMatched:
+		receipt, ok := receipts[l.TxHash]
+		if !ok {
+			if receipt, err = c.client.TransactionReceipt(ctx, l.TxHash); err != nil {
+				return nil, fmt.Errorf("receipt of %s error: %w", l.TxHash.Hex(), err)
+			}
+			receipts[l.TxHash] = receipt
+		}
+		sales, err = decodeOrdersMatched(l, receipt)
+	}
+	if err != nil {
+		c.Sugar.Warnf("decode sale %s:%d error: %s", l.TxHash.Hex(), l.Index, err)
+		return nil, nil
+	}
+	return sales, nil
+}
+
+// decodeTransfer converts a transfer log to records, one for each token.
+// ERC-20 `Transfer` (tokenId not indexed) and unknown logs have no record.
+func decodeTransfer(l types.Log) ([]Record, error) {
+	if len(l.Topics) == 0 {
+		return nil, nil
+	}
+	var from, to common.Address
+	var ids, amounts []*big.Int
+	switch l.Topics[0] {
+	case topicTransfer:
+		if len(l.Topics) != 4 {
+			return nil, nil
+		}
+		from, to = common.BytesToAddress(l.Topics[1].Bytes()), common.BytesToAddress(l.Topics[2].Bytes())
+		ids = []*big.Int{l.Topics[3].Big()}
+		amounts = []*big.Int{big.NewInt(1)}
+	case topicTransferSingle:
+		if len(l.Topics) != 4 || len(l.Data) != 64 {
+			return nil, fmt.Errorf("bad TransferSingle log")
+		}
+		from, to = common.BytesToAddress(l.Topics[2].Bytes()), common.BytesToAddress(l.Topics[3].Bytes())
+		ids = []*big.Int{new(big.Int).SetBytes(l.Data[:32])}
+		amounts = []*big.Int{new(big.Int).SetBytes(l.Data[32:])}
+	case topicTransferBatch:
+		if len(l.Topics) != 4 {
+			return nil, fmt.Errorf("bad TransferBatch log")
+		}
+		from, to = common.BytesToAddress(l.Topics[2].Bytes()), common.BytesToAddress(l.Topics[3].Bytes())
+		values, err := transferBatchData.Unpack(l.Data)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if ids, ok = values[0].([]*big.Int); !ok {
+			return nil, fmt.Errorf("bad TransferBatch ids")
+		}
+		if amounts, ok = values[1].([]*big.Int); !ok || len(amounts) != len(ids) {
+			return nil, fmt.Errorf("bad TransferBatch values")
+		}
+	default:
+		return nil, nil
+	}
+	r := Record{
+		TxHash:      l.TxHash.Hex(),
+		Contract:    l.Address.Hex(),
+		Event:       EventTransfer,
+		FromAddress: from.Hex(),
+		ToAddress:   to.Hex(),
+	}
+	if from == (common.Address{}) {
+		r.Event = EventMint
+	}
+	records := make([]Record, 0, len(ids))
+	for i, id := range ids {
+		r.Id = id.String()
+		r.Quantity = amounts[i].Int64()
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// requestChain reads the transfer logs and the marketplace sales of all project contracts (and ChainConf.Contracts)
+// from the block watermark to the confirmed head, and saves them for the bots.
+// The blocks are read maxBlocks at a time and the watermark is advanced after every chunk,
+// so a long outage is caught up chunk by chunk. The first run starts from the head, no history is read.
+func (s *OpenSea) requestChain(ctx context.Context) error {
+	head, err := s.chain.Head(ctx)
+	if err != nil {
+		return err
+	}
+	last, ok, err := s.loadBlockWatermark(ctx, SourceChain)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.Sugar.Infof("chain events start from block %d", head)
+		return s.saveBlockWatermark(ctx, SourceChain, head)
+	}
+	if last >= head {
+		return nil
+	}
+	topN := make(map[string]Project)
+	if err = s.loadProjects(ctx, topN); err != nil {
+		return err
+	}
+	chats, err := LoadChats(ctx, s.db, "")
+	if err != nil {
+		return err
+	}
+	projects := make(map[string]Project) // by contract
+	for _, p := range topN {
+		for _, a := range p.contracts() {
+			projects[a] = p
+		}
+	}
+	var contracts []common.Address
+	for a := range projects {
+		contracts = append(contracts, common.HexToAddress(a))
+	}
+	for _, a := range s.chainContracts {
+		if _, ok := projects[a.Hex()]; !ok {
+			contracts = append(contracts, a)
+		}
+	}
+	if len(contracts) == 0 {
+		return s.saveBlockWatermark(ctx, SourceChain, head)
+	}
+
+	tracked := s.trackedWallets(chats)
+	for from := last + 1; from <= head; from += s.chain.maxBlocks {
+		to := from + s.chain.maxBlocks - 1
+		if to > head {
+			to = head
+		}
+		if err = s.requestChainBlocks(ctx, contracts, projects, tracked, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestChainBlocks saves the events of the contracts in blocks [from, to] and advances the watermark to `to`.
+func (s *OpenSea) requestChainBlocks(ctx context.Context, contracts []common.Address, projects map[string]Project,
+	tracked wallets, from, to uint64) error {
+	events, err := s.chain.Fetch(ctx, contracts, from, to)
+	if err != nil {
+		return err
+	}
+	s.Sugar.Infof("chain events size = %d in blocks %d - %d", len(events), from, to)
+	tracked.mark(events)
+	s.rates.update(events)
+	for i := range events {
+		events[i].Source = SourceChain
+		if p, ok := projects[events[i].Contract]; ok {
+			events[i].Collection, events[i].Slug = p.Name, p.Slug
+		}
+	}
+	events = s.eventTypes.filter(events)
+	if len(events) > 0 {
+		if events, err = s.filterSeen(ctx, events); err != nil {
+			return err
+		}
+		if err = s.saveFresh(ctx, events, s.saveChainEvent); err != nil {
+			return err
+		}
+	}
+	return s.saveBlockWatermark(ctx, SourceChain, to)
+}
@@ -0,0 +1,257 @@
+package opensea
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"go.uber.org/zap"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// emitterCode deploys a contract emitting LOG4 with the first 4 words of calldata as topics
+// and the rest as data, so any transfer log can be emitted.
+var emitterCode = common.FromHex("601b600c600039601b6000f3" +
+	"60803603806080600037606035604035602035600035846000a400")
+
+// emitter3Code is emitterCode with LOG3, 3 words of topics.
+var emitter3Code = common.FromHex("6018600c60003960186000f3" +
+	"60603603806060600037604035602035600035836000a300")
+
+// emitter2Code emits two LOG4 in one transaction, the first with calldata[0:128] as topics and no data,
+// the second with calldata[128:256] as topics and the rest as data.
+var emitter2Code = common.FromHex("602e600c600039602e6000f3" +
+	"60603560403560203560003560006000a4" +
+	"61010036038061010060003760e03560c03560a035608035846000a400")
+
+type simulatedChain struct {
+	t       *testing.T
+	backend *backends.SimulatedBackend
+	key     *ecdsa.PrivateKey
+	from    common.Address
+	nonce   uint64
+}
+
+func newSimulatedChain(t *testing.T) *simulatedChain {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	balance := new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{from: {Balance: balance}}, 8000000)
+	return &simulatedChain{t: t, backend: backend, key: key, from: from}
+}
+
+// send sends a transaction to `to` (nil to deploy) and mines it in a new block.
+func (c *simulatedChain) send(to *common.Address, data []byte) *types.Transaction {
+	ctx := context.Background()
+	head, err := c.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	gasPrice := new(big.Int).Mul(head.BaseFee, big.NewInt(2))
+	tx := types.NewTx(&types.LegacyTx{Nonce: c.nonce, To: to, Gas: 1000000, GasPrice: gasPrice, Data: data})
+	chainID := big.NewInt(1337)
+	if tx, err = types.SignTx(tx, types.LatestSignerForChainID(chainID), c.key); err != nil {
+		c.t.Fatal(err)
+	}
+	if err = c.backend.SendTransaction(ctx, tx); err != nil {
+		c.t.Fatal(err)
+	}
+	c.nonce++
+	c.backend.Commit()
+	return tx
+}
+
+func (c *simulatedChain) deploy(code []byte) common.Address {
+	contract := crypto.CreateAddress(c.from, c.nonce)
+	c.send(nil, code)
+	return contract
+}
+
+func (c *simulatedChain) emit(contract common.Address, topics []common.Hash, data []byte) common.Hash {
+	var input []byte
+	for _, topic := range topics {
+		input = append(input, topic.Bytes()...)
+	}
+	return c.send(&contract, append(input, data...)).Hash()
+}
+
+func words(values ...int64) []byte {
+	var data []byte
+	for _, v := range values {
+		data = append(data, common.BigToHash(big.NewInt(v)).Bytes()...)
+	}
+	return data
+}
+
+func addressTopic(a common.Address) common.Hash {
+	return common.BytesToHash(a.Bytes())
+}
+
+func TestChainSource(t *testing.T) {
+	chain := newSimulatedChain(t)
+	defer chain.backend.Close()
+	erc721, erc1155, other := chain.deploy(emitterCode), chain.deploy(emitterCode), chain.deploy(emitterCode)
+	alice := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	bob := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	zero := common.Address{}
+
+	mint := chain.emit(erc721, []common.Hash{topicTransfer, addressTopic(zero), addressTopic(alice), common.BigToHash(big.NewInt(1))}, nil)
+	single := chain.emit(erc1155, []common.Hash{topicTransferSingle, addressTopic(alice), addressTopic(alice), addressTopic(bob)},
+		words(5, 2))
+	// ids [7, 8], values [1, 1]
+	batch := chain.emit(erc1155, []common.Hash{topicTransferBatch, addressTopic(alice), addressTopic(zero), addressTopic(bob)},
+		words(64, 160, 2, 7, 8, 2, 1, 1))
+	chain.emit(other, []common.Hash{topicTransfer, addressTopic(zero), addressTopic(alice), common.BigToHash(big.NewInt(9))}, nil)
+	chain.backend.Commit()
+	chain.backend.Commit()
+
+	ctx := context.Background()
+	source, err := NewChainSource(chain.backend, ChainConf{MaxBlocks: 2}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := source.Head(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 7 transactions and 2 empty blocks, 2 confirmations
+	if head != 7 {
+		t.Errorf("head = %d, want 7", head)
+	}
+	records, err := source.Fetch(ctx, []common.Address{erc721, erc1155}, 1, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Record{
+		{TxHash: mint.Hex(), Contract: erc721.Hex(), Id: "1", Event: EventMint, FromAddress: zero.Hex(), ToAddress: alice.Hex(), Quantity: 1},
+		{TxHash: single.Hex(), Contract: erc1155.Hex(), Id: "5", Event: EventTransfer, FromAddress: alice.Hex(), ToAddress: bob.Hex(), Quantity: 2},
+		{TxHash: batch.Hex(), Contract: erc1155.Hex(), Id: "7", Event: EventMint, FromAddress: zero.Hex(), ToAddress: bob.Hex(), Quantity: 1},
+		{TxHash: batch.Hex(), Contract: erc1155.Hex(), Id: "8", Event: EventMint, FromAddress: zero.Hex(), ToAddress: bob.Hex(), Quantity: 1},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("%d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i, w := range want {
+		r := records[i]
+		if r.TxHash != w.TxHash || r.Contract != w.Contract || r.Id != w.Id || r.Event != w.Event ||
+			r.FromAddress != w.FromAddress || r.ToAddress != w.ToAddress || r.Quantity != w.Quantity || r.Time.IsZero() {
+			t.Errorf("%d: %+v, want %+v", i, r, w)
+		}
+	}
+}
+
+func TestDecodeTransferERC20(t *testing.T) {
+	l := types.Log{
+		Topics: []common.Hash{topicTransfer, {}, {}},
+		Data:   common.LeftPadBytes(big.NewInt(100).Bytes(), 32),
+	}
+	records, err := decodeTransfer(l)
+	if err != nil || len(records) != 0 {
+		t.Errorf("ERC-20 transfer = %v, %s", records, err)
+	}
+}
+
+func TestChainSales(t *testing.T) {
+	chain := newSimulatedChain(t)
+	defer chain.backend.Close()
+	seaport, wyvern := chain.deploy(emitter3Code), chain.deploy(emitter2Code)
+	erc721 := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	weth := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	alice := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	bob := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	fee := common.HexToAddress("0x4000000000000000000000000000000000000004")
+	ether := func(milli int64) *big.Int {
+		return new(big.Int).Mul(big.NewInt(milli), big.NewInt(1e15))
+	}
+
+	fulfilled := marketEvents.Events["OrderFulfilled"].Inputs.NonIndexed()
+	// alice lists #3 for 1 ETH, bob buys
+	listing, err := fulfilled.Pack([32]byte{1}, bob,
+		[]spentItem{{itemERC721, erc721, big.NewInt(3), big.NewInt(1)}},
+		[]receivedItem{
+			{itemNative, common.Address{}, big.NewInt(0), ether(975), alice},
+			{itemNative, common.Address{}, big.NewInt(0), ether(25), fee},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := chain.emit(seaport, []common.Hash{topicOrderFulfilled, addressTopic(alice), {}}, listing)
+	// bob offers 2 WETH for #4, alice accepts
+	offer, err := fulfilled.Pack([32]byte{2}, alice,
+		[]spentItem{{itemERC20, weth, big.NewInt(0), ether(2000)}},
+		[]receivedItem{
+			{itemERC721, erc721, big.NewInt(4), big.NewInt(1), bob},
+			{itemERC20, weth, big.NewInt(0), ether(50), fee},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	offered := chain.emit(seaport, []common.Hash{topicOrderFulfilled, addressTopic(bob), {}}, offer)
+	// alice lists #7 and #8 as a bundle for 3 ETH, bob buys
+	bundle, err := fulfilled.Pack([32]byte{3}, bob,
+		[]spentItem{{itemERC721, erc721, big.NewInt(7), big.NewInt(1)}, {itemERC721, erc721, big.NewInt(8), big.NewInt(1)}},
+		[]receivedItem{{itemNative, common.Address{}, big.NewInt(0), ether(3000), alice}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundled := chain.emit(seaport, []common.Hash{topicOrderFulfilled, addressTopic(alice), {}}, bundle)
+	// alice sells #6 of the wyvern contract itself to bob for 1.5 ETH,
+	// the transfer log of a marketplace is only used for its sale
+	transfer := []common.Hash{topicTransfer, addressTopic(alice), addressTopic(bob), common.BigToHash(big.NewInt(6))}
+	matched := []common.Hash{topicOrdersMatched, addressTopic(alice), addressTopic(bob), {}}
+	var input []byte
+	for _, topic := range append(transfer, matched...) {
+		input = append(input, topic.Bytes()...)
+	}
+	input = append(input, append(words(1, 2), common.BigToHash(ether(1500)).Bytes()...)...)
+	sold := chain.send(&wyvern, input).Hash()
+	chain.backend.Commit()
+	chain.backend.Commit()
+
+	ctx := context.Background()
+	source, err := NewChainSource(chain.backend, ChainConf{Marketplaces: []string{seaport.Hex(), wyvern.Hex()}}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := source.Head(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := source.Fetch(ctx, []common.Address{erc721, wyvern}, 1, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Record{
+		{TxHash: listed.Hex(), Contract: erc721.Hex(), Id: "3", Event: EventSale, FromAddress: alice.Hex(), ToAddress: bob.Hex()},
+		{TxHash: offered.Hex(), Contract: erc721.Hex(), Id: "4", Event: EventSale, FromAddress: alice.Hex(), ToAddress: bob.Hex()},
+		{TxHash: bundled.Hex(), Contract: erc721.Hex(), Id: "7", Event: EventSale, FromAddress: alice.Hex(), ToAddress: bob.Hex(), Bundle: 2},
+		{TxHash: bundled.Hex(), Contract: erc721.Hex(), Id: "8", Event: EventSale, FromAddress: alice.Hex(), ToAddress: bob.Hex(), Bundle: 2},
+		{TxHash: sold.Hex(), Contract: wyvern.Hex(), Id: "6", Event: EventSale, FromAddress: alice.Hex(), ToAddress: bob.Hex()},
+	}
+	// the bundle price is split
+	prices := []string{"1 ETH", "2 WETH", "1.5 ETH", "1.5 ETH", "1.5 ETH"}
+	if len(records) != len(want) {
+		t.Fatalf("%d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i, w := range want {
+		r := records[i]
+		if r.TxHash != w.TxHash || r.Contract != w.Contract || r.Id != w.Id || r.Event != w.Event ||
+			priceText(r) != prices[i] || r.FromAddress != w.FromAddress || r.ToAddress != w.ToAddress || r.Bundle != w.Bundle {
+			t.Errorf("%d: %+v, want %+v", i, r, w)
+		}
+	}
+	// saved in chain order, not reversed like the API records
+	for i, doc := range eventDocs(records, false, time.Now()) {
+		if r := doc.(Record); r.TxHash != want[i].TxHash || r.Id != want[i].Id {
+			t.Errorf("saved %d: %s #%s, want %s #%s", i, r.TxHash, r.Id, want[i].TxHash, want[i].Id)
+		}
+	}
+}
@@ -2,15 +2,14 @@ package opensea
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/xyths/hs"
+	"github.com/xyths/opensea-monitor/opensea/api"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
-	"net/http"
 	"time"
 )
 
@@ -21,14 +20,16 @@ type CollectionConfig struct {
 	Log      hs.LogConf
 	Interval string
 	Top      int
+	API      api.Config `json:"api"`
 }
 
 type Collection struct {
 	cfg      CollectionConfig
 	interval time.Duration
 
-	Sugar *zap.SugaredLogger
-	db    *mongo.Database
+	Sugar  *zap.SugaredLogger
+	db     *mongo.Database
+	client *api.Client
 }
 
 func NewCollection(cfg CollectionConfig) *Collection {
@@ -54,6 +55,12 @@ func (c *Collection) Init(ctx context.Context) error {
 	}
 	c.db = db
 	c.Sugar.Info("database initialized")
+	c.client, err = api.New(c.cfg.API)
+	if err != nil {
+		c.Sugar.Errorf("OpenSea API client init error: %s", err)
+		return err
+	}
+	c.Sugar.Infof("OpenSea API client initialized, endpoint: %s", c.client.BaseURL())
 	c.Sugar.Info("Collection initialized")
 	return nil
 }
@@ -108,37 +115,26 @@ func (c *Collection) UpdateDaemon(ctx context.Context) error {
 }
 
 // RetrieveCollections call OpenSea API to retrieve collections.
-// Request like this:
-// curl --request GET \
-//     --url 'https://api.opensea.io/api/v1/collections?offset=0&limit=300'
 func (c *Collection) RetrieveCollections(ctx context.Context, offset, limit int) ([]Item, error) {
-	url := fmt.Sprintf("https://api.opensea.io/api/v1/collections?offset=%d&limit=%d", offset, limit)
-	client := &http.Client{}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := client.Do(req)
+	responseCollections, err := c.client.Collections(ctx, offset, limit)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	var responseCollections ResponseCollections
-	decoder := json.NewDecoder(resp.Body)
-	if err = decoder.Decode(&responseCollections); err != nil {
-		return nil, err
-	}
 	var collections []Item
 	for _, cc := range responseCollections.Collections {
 		item := Item{
 			Name:  cc.Name,
+			Slug:  cc.Slug,
 			Stats: cc.Stats,
 		}
-		l := len(cc.PrimaryAssetContracts)
-		if l >= 2 {
-			c.Sugar.Infof("Collection \"%s\" has %d primary_asset_contracts", cc.Name, l)
-		} else if l == 1 {
-			item.Address = cc.PrimaryAssetContracts[0].Address
+		for _, ac := range cc.PrimaryAssetContracts {
+			item.Addresses = append(item.Addresses, common.HexToAddress(ac.Address).Hex())
+		}
+		if l := len(item.Addresses); l >= 2 {
+			c.Sugar.Debugf("Collection \"%s\" has %d primary_asset_contracts", cc.Name, l)
+		}
+		if len(item.Addresses) > 0 {
+			item.Address = item.Addresses[0]
 		}
 
 		collections = append(collections, item)
@@ -151,12 +147,13 @@ func (c *Collection) saveCollections(ctx context.Context, collections []Item) er
 	opt := options.FindOneAndReplace().SetUpsert(true)
 	for _, cc := range collections {
 		//c.Sugar.Debugf("%s %s %f", cc.Address, cc.Name, cc.Stats.SevenDayVolume)
-		if cc.Stats.SevenDayVolume < 100 || cc.Address == "" {
+		// collections without primary contract (shared contract) are polled by slug too
+		if cc.Stats.SevenDayVolume < 100 || cc.Slug == "" {
 			continue
 		}
 
 		if err := coll.FindOneAndReplace(ctx,
-			bson.D{{"address", cc.Address}},
+			bson.D{{"slug", cc.Slug}},
 			cc,
 			opt,
 		).Err(); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
@@ -0,0 +1,43 @@
+package opensea
+
+import "fmt"
+
+// ProjectError is the failure of fetching events of one project.
+type ProjectError struct {
+	Project string
+	Err     error
+}
+
+func (e *ProjectError) Error() string {
+	return fmt.Sprintf("project %s: %s", e.Project, e.Err)
+}
+
+func (e *ProjectError) Unwrap() error {
+	return e.Err
+}
+
+// PollError is returned by one poll round when some projects failed,
+// the watermark must not be advanced over the failed projects.
+type PollError struct {
+	Total  int
+	Failed []*ProjectError
+}
+
+func (e *PollError) Error() string {
+	if len(e.Failed) == 0 {
+		return fmt.Sprintf("0 of %d projects failed", e.Total)
+	}
+	return fmt.Sprintf("%d of %d projects failed, first: %s", len(e.Failed), e.Total, e.Failed[0])
+}
+
+func (e *PollError) add(project string, err error) {
+	e.Failed = append(e.Failed, &ProjectError{Project: project, Err: err})
+}
+
+// err returns nil if no project failed.
+func (e *PollError) err() error {
+	if len(e.Failed) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,95 @@
+package opensea
+
+import (
+	"context"
+	"fmt"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// eventsPageLimit is the max `limit` of the events API.
+	eventsPageLimit = 300
+	// eventsMaxOffset is the max `offset` accepted by the events API.
+	eventsMaxOffset = 10000
+)
+
+// fetchEvents fetches all events matching the query (contract, token, event type ...) in [from, to),
+// page by page, newest first.
+// It follows the `next` cursor of the response, and only falls back to `offset` if no cursor is returned.
+// Paging stops when an event older than `from` is met, or no more page.
+// Any page failure fails the whole window, no partial result is returned.
+func fetchEvents(ctx context.Context, client *api.Client, sugar *zap.SugaredLogger,
+	q api.EventsQuery, from, to time.Time) ([]Record, error) {
+	var events []Record
+	q.OccurredAfter, q.OccurredBefore = from, to
+	q.Cursor, q.Offset, q.Limit = "", 0, eventsPageLimit
+	cursors := make(map[string]bool)
+	for page := 0; ; page++ {
+		sugar.Infof("request events: %s, page = %d, offset = %d", q, page, q.Offset)
+		resp, err := client.Events(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Success != nil && !(*resp.Success) {
+			sugar.Debugf("request failed")
+		}
+		boundary := false
+		for _, ae := range resp.AssetEvents {
+			if t, ok := eventTime(ae); ok {
+				if !t.Before(to) {
+					// occurred after the window, it belongs to the next window
+					continue
+				}
+				if t.Before(from) {
+					boundary = true
+					continue
+				}
+			}
+			events = append(events, toRecord(ae))
+		}
+		sugar.Debugf("records outside size = %d", len(events))
+		if boundary {
+			break
+		}
+		if next := resp.NextCursor(); next != "" {
+			if cursors[next] {
+				return nil, fmt.Errorf("events of %s: cursor %s is returned twice", q, next)
+			}
+			cursors[next] = true
+			q.Cursor = next
+			continue
+		}
+		if q.Cursor != "" || len(resp.AssetEvents) < eventsPageLimit {
+			break
+		}
+		// no cursor from the API, fall back to offset
+		q.Offset += len(resp.AssetEvents)
+		if q.Offset >= eventsMaxOffset {
+			sugar.Warnf("events of %s in %s - %s exceed max offset %d, the older ones are skipped",
+				q, from, to, eventsMaxOffset)
+			break
+		}
+	}
+	return events, nil
+}
+
+// eventTime returns when the event occurred.
+func eventTime(ae api.AssetEvent) (time.Time, bool) {
+	date := ae.EventTimestamp
+	if date == "" {
+		date = ae.CreatedDate
+	}
+	return parseTime(date)
+}
+
+// parseTime parses the UTC time of the API, like "2021-08-28T09:44:43.664713".
+func parseTime(date string) (time.Time, bool) {
+	layout := "2006-01-02T15:04:05.999999"
+	t, err := time.Parse(layout, date)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,99 @@
+package opensea
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// mockEvents serves `total` events one second apart, newest first, `limit` per page.
+// With cursor, `next` is the index of the first event of next page.
+func mockEvents(t *testing.T, total int, newest time.Time, withCursor bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		start, _ := strconv.Atoi(q.Get("offset"))
+		if c := q.Get("cursor"); c != "" {
+			if !withCursor {
+				t.Errorf("cursor %s sent without cursor from server", c)
+			}
+			start, _ = strconv.Atoi(c)
+		}
+		var resp api.ResponseEvent
+		for i := start; i < start+limit && i < total; i++ {
+			resp.AssetEvents = append(resp.AssetEvents, api.AssetEvent{
+				Id:             int64(i + 1),
+				EventType:      api.EventTypeList,
+				EventTimestamp: newest.Add(-time.Duration(i) * time.Second).UTC().Format("2006-01-02T15:04:05"),
+				FromAccount:    &api.Account{},
+			})
+		}
+		if withCursor && start+limit < total {
+			next := strconv.Itoa(start + limit)
+			resp.Next = &next
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestFetchEvents(t *testing.T) {
+	newest := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		total      int
+		withCursor bool
+		from, to   time.Time
+		want       int
+	}{
+		{total: 700, withCursor: true, from: newest.Add(-time.Hour), to: newest.Add(time.Second), want: 700},
+		{total: 700, withCursor: false, from: newest.Add(-time.Hour), to: newest.Add(time.Second), want: 700},
+		// stop at the window boundary: events at 12:00:00 ... 11:55:00
+		{total: 700, withCursor: true, from: newest.Add(-5 * time.Minute), to: newest.Add(time.Second), want: 301},
+		// the newest is after the window
+		{total: 10, withCursor: true, from: newest.Add(-time.Hour), to: newest, want: 9},
+		{total: 0, withCursor: true, from: newest.Add(-time.Hour), to: newest, want: 0},
+	}
+	for i, tt := range tests {
+		srv := mockEvents(t, tt.total, newest, tt.withCursor)
+		client, err := api.New(api.Config{BaseURL: srv.URL, RateLimit: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		events, err := fetchEvents(context.Background(), client, zap.NewNop().Sugar(), api.EventsQuery{AssetContractAddress: "0xabc"}, tt.from, tt.to)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if len(events) != tt.want {
+			t.Errorf("%d: %d events, want %d", i, len(events), tt.want)
+		}
+		ids := make(map[string]bool)
+		for _, e := range events {
+			ids[e.EventId] = true
+		}
+		if len(ids) != len(events) {
+			t.Errorf("%d: duplicated events", i)
+		}
+	}
+}
+
+func TestFetchEventsRepeatedCursor(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"asset_events":[],"next":"same"}`)
+	}))
+	defer srv.Close()
+	client, err := api.New(api.Config{BaseURL: srv.URL, RateLimit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err = fetchEvents(context.Background(), client, zap.NewNop().Sugar(), api.EventsQuery{AssetContractAddress: "0xabc"}, now.Add(-time.Hour), now); err == nil {
+		t.Error("repeated cursor should be error")
+	}
+}
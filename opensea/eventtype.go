@@ -0,0 +1,133 @@
+package opensea
+
+import (
+	"fmt"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"sort"
+)
+
+// apiEventTypes maps the event of Record to `event_type` of the events API.
+// Mint and Transfer are both `transfer` in the API.
+var apiEventTypes = map[string]string{
+	EventSale:       api.EventTypeSale,
+	EventOffer:      api.EventTypeOffer,
+	EventBid:        api.EventTypeBid,
+	EventBidCancel:  api.EventTypeBidCancel,
+	EventListCancel: api.EventTypeCancel,
+	EventTransfer:   api.EventTypeTransfer,
+	EventMint:       api.EventTypeTransfer,
+	EventList:       api.EventTypeList,
+}
+
+// eventTypes is a set of events of Record, nil means all events.
+type eventTypes map[string]bool
+
+func newEventTypes(events []string) (eventTypes, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	t := make(eventTypes, len(events))
+	for _, e := range events {
+		if _, ok := apiEventTypes[e]; !ok {
+			return nil, fmt.Errorf("unknown event type %s", e)
+		}
+		t[e] = true
+	}
+	return t, nil
+}
+
+func (t eventTypes) has(event string) bool {
+	return t == nil || t[event]
+}
+
+// list returns the events in order, nil means all events.
+func (t eventTypes) list() []string {
+	if t == nil {
+		return nil
+	}
+	events := make([]string, 0, len(t))
+	for e := range t {
+		events = append(events, e)
+	}
+	sort.Strings(events)
+	return events
+}
+
+// listingEvents are the events the trait book keeps the active listings by.
+var listingEvents = []string{EventList, EventSale, EventListCancel, EventTransfer, EventMint}
+
+// wantedEvents returns the events to request the project with,
+// the events wanted by every chat subscribing the project within the global config, nil means all.
+// If listings are kept, the listingEvents are requested for sniping chats whatever the filters,
+// the unwanted ones are dropped after the listings are updated.
+func wantedEvents(global eventTypes, chats []Configuration, project Project, listings bool) []string {
+	wanted := make(eventTypes)
+	subscribed, all, sniping := false, false, false
+	for _, chat := range chats {
+		if !chat.wantsCollection(project) {
+			continue
+		}
+		subscribed = true
+		if chat.sniping() {
+			sniping = true
+			if global.has(EventList) {
+				wanted[EventList] = true
+			}
+		}
+		events, err := newEventTypes(chat.EventTypes)
+		if err != nil || events == nil {
+			// all types of this chat, only the global config restricts
+			all = true
+			continue
+		}
+		for e := range events {
+			if global.has(e) {
+				wanted[e] = true
+			}
+		}
+	}
+	if !subscribed || all {
+		if global == nil {
+			return nil
+		}
+		wanted = make(eventTypes, len(global))
+		for e := range global {
+			wanted[e] = true
+		}
+	}
+	if subscribed && sniping && listings {
+		for _, e := range listingEvents {
+			wanted[e] = true
+		}
+	}
+	return wanted.list()
+}
+
+// filter returns the records of the events, in place.
+func (t eventTypes) filter(records []Record) []Record {
+	if t == nil {
+		return records
+	}
+	wanted := records[:0]
+	for _, r := range records {
+		if t.has(r.Event) {
+			wanted = append(wanted, r)
+		}
+	}
+	return wanted
+}
+
+// apiEventType returns the `event_type` of the OpenSea API when the events are one API event type,
+// otherwise "" (all types).
+func apiEventType(events []string) string {
+	apiTypes := make(map[string]bool)
+	for _, e := range events {
+		apiTypes[apiEventTypes[e]] = true
+	}
+	if len(apiTypes) == 1 {
+		for t := range apiTypes {
+			return t
+		}
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package opensea
+
+import (
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"reflect"
+	"testing"
+)
+
+func TestApiEventType(t *testing.T) {
+	const a, b = "0x1A92f7381B9F03921564a437210bB9396471050C", "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"
+	sales := Configuration{Projects: []ProjectConf{{Address: a}}, EventTypes: []string{EventSale}}
+	lists := Configuration{Projects: []ProjectConf{{Address: b}}, EventTypes: []string{EventList}}
+	transfers := Configuration{EventTypes: []string{EventMint, EventTransfer}}
+	all := Configuration{}
+	// a collection with contracts a and b
+	multi := Project{Slug: "multi", Addresses: []string{a, b}}
+	lists2 := Configuration{Projects: []ProjectConf{{Slug: "multi"}}, EventTypes: []string{EventList}}
+	sniper := Configuration{Projects: []ProjectConf{{Address: a}}, EventTypes: []string{EventSale}, Snipe: 80}
+	onlySales, _ := newEventTypes([]string{EventSale})
+	tests := []struct {
+		global   eventTypes
+		chats    []Configuration
+		project  Project
+		listings bool
+		want     string
+	}{
+		{nil, nil, Project{Address: a}, false, ""},
+		{onlySales, nil, Project{Address: a}, false, api.EventTypeSale},
+		{nil, []Configuration{sales, lists}, Project{Address: a}, false, api.EventTypeSale},
+		{nil, []Configuration{sales, lists}, Project{Address: b}, false, api.EventTypeList},
+		{nil, []Configuration{sales, transfers}, Project{Address: a}, false, ""},
+		{nil, []Configuration{transfers}, Project{Address: b}, false, api.EventTypeTransfer},
+		{nil, []Configuration{sales, all}, Project{Address: a}, false, ""},
+		{onlySales, []Configuration{sales, all}, Project{Address: a}, false, api.EventTypeSale},
+		// followed by one of the contracts
+		{nil, []Configuration{sales, lists}, multi, false, ""},
+		{nil, []Configuration{sales}, multi, false, api.EventTypeSale},
+		{nil, []Configuration{lists2}, multi, false, api.EventTypeList},
+		// listings are requested for below-floor alerts
+		{nil, []Configuration{sniper}, Project{Address: a}, false, ""},
+		{onlySales, []Configuration{sniper}, Project{Address: a}, false, api.EventTypeSale},
+		// all events the listings are kept by, whatever the filters
+		{onlySales, []Configuration{sniper}, Project{Address: a}, true, ""},
+		{onlySales, []Configuration{sales}, Project{Address: a}, true, api.EventTypeSale},
+	}
+	for i, tt := range tests {
+		if got := apiEventType(wantedEvents(tt.global, tt.chats, tt.project, tt.listings)); got != tt.want {
+			t.Errorf("%d: event_type = %q, want %q", i, got, tt.want)
+		}
+	}
+	got := wantedEvents(onlySales, []Configuration{sniper}, Project{Address: a}, true)
+	if want := []string{EventList, EventListCancel, EventMint, EventSale, EventTransfer}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sniping events = %v, want %v", got, want)
+	}
+	if _, err := newEventTypes([]string{"successful"}); err == nil {
+		t.Error("API event type is not a Record event")
+	}
+}
+
+func TestConfigurationWants(t *testing.T) {
+	chat := Configuration{
+		Projects:   []ProjectConf{{Address: "0x1a92f7381b9f03921564a437210bb9396471050c"}, {Slug: "multi"}},
+		EventTypes: []string{EventSale, EventList},
+		Assets:     []AssetConf{{Contract: "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d", TokenId: "7"}},
+		Wallets:    []string{"0x0000000000000000000000000000000000000001"},
+		Sources:    []string{SourceOpenSea, SourceChain},
+	}
+	tests := []struct {
+		r    Record
+		want bool
+	}{
+		{Record{Contract: "0x1A92f7381B9F03921564a437210bB9396471050C", Event: EventSale}, true},
+		{Record{Contract: "0x1A92f7381B9F03921564a437210bB9396471050C", Event: EventBid}, false},
+		{Record{Contract: "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D", Event: EventSale}, false},
+		// watched token, any event
+		{Record{Contract: "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D", Id: "7", Event: EventBid}, true},
+		{Record{Contract: "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D", Id: "8", Event: EventBid}, false},
+		// tracked wallet, any collection
+		{Record{Contract: "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D", Event: EventBid, Extra: true,
+			Wallets: []string{"0x0000000000000000000000000000000000000001"}}, true},
+		// polled for another chat
+		{Record{Contract: "0x1A92f7381B9F03921564a437210bB9396471050C", Event: EventSale, Extra: true}, false},
+		// by slug
+		{Record{Slug: "multi", Contract: "0x0000000000000000000000000000000000000002", Event: EventList}, true},
+		// source not followed
+		{Record{Source: "looksrare", Contract: "0x1A92f7381B9F03921564a437210bB9396471050C", Event: EventSale}, false},
+		{Record{Source: SourceChain, Contract: "0x1A92f7381B9F03921564a437210bB9396471050C", Event: EventSale}, true},
+	}
+	for i, tt := range tests {
+		if got := chat.Wants(tt.r); got != tt.want {
+			t.Errorf("%d: wants = %v", i, got)
+		}
+	}
+}
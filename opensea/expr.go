@@ -0,0 +1,512 @@
+package opensea
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A filter expression is the text form of a filter, an element of Configuration.Filter:
+//
+//	event in (Sale, List) and price < 0.5 ETH and trait.Background = "Gold"
+//
+//	expr       = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" expr ")" | comparison
+//	comparison = field op value | field [ "not" ] "in" "(" value { "," value } ")"
+//	field      = "event" | "wallet" | "price" | "discount" | "traitDiscount" | "rarity" | "rank" | "trait" "." name
+//	op         = "=" | "!=" | "<" | "<=" | ">" | ">="
+//	value      = name | string | number [ currency ]
+//
+// Keywords and field names are case-insensitive, `properties.X` and `traits.X` are the same as `trait.X`.
+// A price without currency is the ETH value, see priceCmp. The discount is in percent, see discountCmp.
+// The rarity is the rank in percent, `rarity <= 5` is the rarest 5%, see rarityCmp.
+
+// ExprError is an error of a filter expression at the position, Line and Column start from 1.
+type ExprError struct {
+	Offset int // byte offset in the expression
+	Line   int
+	Column int // in runes
+	Msg    string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+func exprError(text string, offset int, format string, args ...interface{}) *ExprError {
+	line, col := 1, 1
+	for _, c := range text[:offset] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return &ExprError{Offset: offset, Line: line, Column: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// CheckFilter compiles the filter expression, returns an *ExprError if it's wrong.
+func CheckFilter(text string) error {
+	_, err := compileExpr(text)
+	return err
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+	tokDot
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string // unquoted for strings
+	pos  int
+}
+
+func (t exprToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("%q", t.text)
+	default:
+		return "`" + t.text + "`"
+	}
+}
+
+// is returns true if the token is the keyword, case-insensitive.
+func (t exprToken) is(keyword string) bool {
+	return t.kind == tokName && strings.EqualFold(t.text, keyword)
+}
+
+// keyword returns true if the token is a keyword of the expression, not a name.
+func (t exprToken) keyword() bool {
+	return t.is("and") || t.is("or") || t.is("not") || t.is("in")
+}
+
+func lex(text string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(text); {
+		c, size := utf8.DecodeRuneInString(text[i:])
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+			continue
+		case c == '(':
+			tokens = append(tokens, exprToken{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, exprToken{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, exprToken{kind: tokComma, text: ",", pos: i})
+			i++
+		case c == '.':
+			tokens = append(tokens, exprToken{kind: tokDot, text: ".", pos: i})
+			i++
+		case c == '<' || c == '>' || c == '=' || c == '!':
+			i++
+			if i < len(text) && text[i] == '=' {
+				i++
+			}
+			op := text[start:i]
+			if op == "!" {
+				return nil, exprError(text, start, "unknown operator !, want !=")
+			}
+			if op == "==" {
+				op = opEQ
+			}
+			tokens = append(tokens, exprToken{kind: tokOp, text: op, pos: start})
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			for i += size; ; {
+				if i >= len(text) {
+					return nil, exprError(text, start, "string not terminated")
+				}
+				r, n := utf8.DecodeRuneInString(text[i:])
+				i += n
+				if r == c {
+					break
+				}
+				if r == '\\' && i < len(text) {
+					r, n = utf8.DecodeRuneInString(text[i:])
+					i += n
+				}
+				b.WriteRune(r)
+			}
+			tokens = append(tokens, exprToken{kind: tokString, text: b.String(), pos: start})
+		case strings.HasPrefix(text[i:], "0x") || strings.HasPrefix(text[i:], "0X"):
+			// address
+			for i += 2; i < len(text) && isNameChar(rune(text[i])); i++ {
+			}
+			tokens = append(tokens, exprToken{kind: tokName, text: text[start:i], pos: start})
+		case c >= '0' && c <= '9':
+			for ; i < len(text) && (text[i] >= '0' && text[i] <= '9' || text[i] == '.'); i++ {
+			}
+			if _, err := decimal.NewFromString(text[start:i]); err != nil {
+				return nil, exprError(text, start, "%s is not a number", text[start:i])
+			}
+			tokens = append(tokens, exprToken{kind: tokNumber, text: text[start:i], pos: start})
+		case isNameChar(c):
+			for i < len(text) {
+				r, n := utf8.DecodeRuneInString(text[i:])
+				if !isNameChar(r) {
+					break
+				}
+				i += n
+			}
+			tokens = append(tokens, exprToken{kind: tokName, text: text[start:i], pos: start})
+		default:
+			return nil, exprError(text, start, "unexpected character %q", c)
+		}
+	}
+	return append(tokens, exprToken{kind: tokEOF, pos: len(text)}), nil
+}
+
+func isNameChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// exprNode is the syntax tree of an expression, checked and compiled to a predicate by compile.
+type exprNode interface {
+	compile(text string) (predicate, error)
+}
+
+type logicNode struct {
+	or   bool
+	args []exprNode
+}
+
+type notNode struct {
+	x exprNode
+}
+
+type exprValue struct {
+	exprToken
+	currency *exprToken // currency of a number
+}
+
+// cmpNode is a comparison, op is `in` or `not in` for lists.
+type cmpNode struct {
+	field  exprToken
+	key    *exprToken // trait type
+	op     exprToken
+	values []exprValue
+}
+
+type exprParser struct {
+	text   string
+	tokens []exprToken
+	i      int
+}
+
+func parseExpr(text string) (exprNode, error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{text: text, tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, exprError(text, 0, "empty expression")
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s, want and, or", t)
+	}
+	return n, nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.i]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *exprParser) errorf(t exprToken, format string, args ...interface{}) error {
+	return exprError(p.text, t.pos, format, args...)
+}
+
+func (p *exprParser) expect(kind tokenKind, want string) (exprToken, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.errorf(t, "unexpected %s, want %s", t, want)
+	}
+	return t, nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseLogic(true, p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseLogic(false, p.parseUnary)
+}
+
+func (p *exprParser) parseLogic(or bool, operand func() (exprNode, error)) (exprNode, error) {
+	keyword := "and"
+	if or {
+		keyword = "or"
+	}
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	args := []exprNode{x}
+	for p.peek().is(keyword) {
+		p.next()
+		if x, err = operand(); err != nil {
+			return nil, err
+		}
+		args = append(args, x)
+	}
+	if len(args) == 1 {
+		return x, nil
+	}
+	return logicNode{or: or, args: args}, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	t := p.peek()
+	switch {
+	case t.is("not"):
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x: x}, nil
+	case t.kind == tokLParen:
+		p.next()
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.expect(tokRParen, "`)`"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	default:
+		return p.parseComparison()
+	}
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	var n cmpNode
+	n.field = p.next()
+	if n.field.kind != tokName || n.field.keyword() {
+		return nil, p.errorf(n.field, "unexpected %s, want a field", n.field)
+	}
+	if p.peek().kind == tokDot {
+		p.next()
+		key := p.next()
+		if key.kind != tokName && key.kind != tokString && key.kind != tokNumber {
+			return nil, p.errorf(key, "unexpected %s, want a trait type", key)
+		}
+		n.key = &key
+	}
+	n.op = p.next()
+	switch {
+	case n.op.kind == tokOp:
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		n.values = []exprValue{v}
+		return n, nil
+	case n.op.is("not"):
+		if t := p.next(); !t.is("in") {
+			return nil, p.errorf(t, "unexpected %s, want in", t)
+		}
+		n.op.text = "not in"
+	case n.op.is("in"):
+		n.op.text = "in"
+	default:
+		return nil, p.errorf(n.op, "unexpected %s, want an operator", n.op)
+	}
+	if _, err := p.expect(tokLParen, "`(`"); err != nil {
+		return nil, err
+	}
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		n.values = append(n.values, v)
+		t := p.next()
+		if t.kind == tokRParen {
+			return n, nil
+		}
+		if t.kind != tokComma {
+			return nil, p.errorf(t, "unexpected %s, want `,` or `)`", t)
+		}
+	}
+}
+
+func (p *exprParser) parseValue() (exprValue, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokString || t.kind == tokName && !t.keyword():
+		return exprValue{exprToken: t}, nil
+	case t.kind == tokNumber:
+		v := exprValue{exprToken: t}
+		if c := p.peek(); c.kind == tokName && !c.keyword() {
+			p.next()
+			v.currency = &c
+		}
+		return v, nil
+	default:
+		return exprValue{}, p.errorf(t, "unexpected %s, want a value", t)
+	}
+}
+
+// compileExpr parses, checks and compiles the expression.
+func compileExpr(text string) (predicate, error) {
+	n, err := parseExpr(text)
+	if err != nil {
+		return nil, err
+	}
+	return n.compile(text)
+}
+
+func (n logicNode) compile(text string) (predicate, error) {
+	ps := make([]predicate, 0, len(n.args))
+	for _, a := range n.args {
+		p, err := a.compile(text)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	if n.or {
+		return anyOf(ps), nil
+	}
+	return allOf(ps), nil
+}
+
+func (n notNode) compile(text string) (predicate, error) {
+	p, err := n.x.compile(text)
+	if err != nil {
+		return nil, err
+	}
+	return negation{p}, nil
+}
+
+func (n cmpNode) compile(text string) (predicate, error) {
+	field := strings.ToLower(n.field.text)
+	if strings.EqualFold(field, FilterTraitDiscount) {
+		field = FilterTraitDiscount
+	}
+	switch field {
+	case FilterEvent, FilterWallet, FilterPrice, FilterDiscount, FilterTraitDiscount, FilterRarity, FilterRank:
+		if n.key != nil {
+			return nil, exprError(text, n.key.pos, "%s has no field %s", field, n.key.text)
+		}
+	case "trait", FilterTraits, FilterProperties:
+		if n.key == nil {
+			return nil, exprError(text, n.field.pos, "%s wants a trait type, like %s.Background", field, field)
+		}
+	default:
+		return nil, exprError(text, n.field.pos, "unknown field %s", n.field.text)
+	}
+
+	var value func(v exprValue) (predicate, error)
+	switch field {
+	case FilterEvent:
+		value = func(v exprValue) (predicate, error) {
+			for e := range knownEvents {
+				if v.kind != tokNumber && v.currency == nil && strings.EqualFold(e, v.text) {
+					return eventIs(e), nil
+				}
+			}
+			return nil, exprError(text, v.pos, "unknown event %s", v.text)
+		}
+	case FilterWallet:
+		value = func(v exprValue) (predicate, error) {
+			if v.kind == tokNumber || !common.IsHexAddress(v.text) {
+				return nil, exprError(text, v.pos, "%s is not an address", v.text)
+			}
+			return walletIs(common.HexToAddress(v.text).Hex()), nil
+		}
+	case FilterPrice, FilterDiscount, FilterTraitDiscount, FilterRarity, FilterRank:
+		if n.op.kind != tokOp {
+			return nil, exprError(text, n.op.pos, "operator %s is not supported by %s", n.op.text, field)
+		}
+		v := n.values[0]
+		if v.kind != tokNumber {
+			return nil, exprError(text, v.pos, "%s wants a number, got %s", field, v.exprToken)
+		}
+		d := decimal.RequireFromString(v.text)
+		if field != FilterPrice {
+			if v.currency != nil {
+				return nil, exprError(text, v.currency.pos, "unexpected %s after the %s", v.currency, field)
+			}
+			return rangeCmp(field, n.op.text, d), nil
+		}
+		currency := defaultCurrency
+		if v.currency != nil {
+			if !knownCurrency(v.currency.text) {
+				return nil, exprError(text, v.currency.pos, "unknown currency %s", v.currency)
+			}
+			currency = strings.ToUpper(v.currency.text)
+		}
+		return priceCmp{currency: currency, op: n.op.text, value: d}, nil
+	default:
+		typ := n.key.text
+		value = func(v exprValue) (predicate, error) {
+			if v.currency != nil {
+				return nil, exprError(text, v.currency.pos, "unexpected %s after the trait value", v.currency)
+			}
+			return traitIs{typ: typ, value: v.text}, nil
+		}
+	}
+
+	switch n.op.text {
+	case opEQ, opNE:
+		p, err := value(n.values[0])
+		if err != nil {
+			return nil, err
+		}
+		if n.op.text == opNE {
+			return negation{p}, nil
+		}
+		return p, nil
+	case "in", "not in":
+		or := make(anyOf, 0, len(n.values))
+		for _, v := range n.values {
+			p, err := value(v)
+			if err != nil {
+				return nil, err
+			}
+			or = append(or, p)
+		}
+		if n.op.text == "not in" {
+			return negation{or}, nil
+		}
+		return or, nil
+	default:
+		return nil, exprError(text, n.op.pos, "operator %s is not supported by %s", n.op.text, field)
+	}
+}
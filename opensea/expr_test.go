@@ -0,0 +1,104 @@
+package opensea
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	eth := func(s string) *Decimal {
+		return NewDecimal(decimal.RequireFromString(s))
+	}
+	alice := "0x1A92f7381B9F03921564a437210bB9396471050C"
+	gold := []Trait{{Type: "Background", Value: "Gold"}, {Type: "Fur Color", Value: "Red"}, {Type: "Level", Value: "3"}}
+	cheap := Record{Event: EventList, Price: eth("0.3"), ETH: eth("0.3"), Payment: Token{Symbol: "ETH"}, Traits: gold, Floor: eth("0.5"),
+		TraitFloor: &TraitFloor{Type: "Background", Value: "Gold", Price: eth("0.6")}, Rarity: &Rarity{Rank: 123, Total: 10000}}
+	dear := Record{Event: EventSale, Price: eth("3000"), ETH: eth("2"), USD: eth("3000"), Payment: Token{Symbol: "USDC"}, FromAddress: alice,
+		Rarity: &Rarity{Rank: 900, Total: 10000}}
+	mint := Record{Event: EventMint, ToAddress: alice}
+
+	tests := []struct {
+		expr string
+		want []bool // cheap, dear, mint
+	}{
+		{`event in (Sale, List) and price < 0.5 ETH and trait.Background = "Gold"`, []bool{true, false, false}},
+		{`event = mint`, []bool{false, false, true}},
+		{`EVENT != Mint`, []bool{true, true, false}},
+		{`event not in (Sale, "List")`, []bool{false, false, true}},
+		{`price < 0.5`, []bool{true, false, false}},
+		{`price >= 2500 usd`, []bool{false, true, false}},
+		{`price <= 3000 USDC`, []bool{false, true, false}},
+		{`price != 2`, []bool{true, false, false}},
+		{`price == 2`, []bool{false, true, false}},
+		{`wallet = ` + alice, []bool{false, true, true}},
+		{`wallet in ("0x1a92f7381b9f03921564a437210bb9396471050c")`, []bool{false, true, true}},
+		{`properties."Fur Color" = red and traits.level = 3`, []bool{true, false, false}},
+		{`trait.Background != Gold`, []bool{false, true, true}},
+		// and binds tighter than or
+		{`event = Mint or event = List and price > 1`, []bool{false, false, true}},
+		{`(event = Mint or event = List) and not price > 1`, []bool{true, false, true}},
+		{`not not event = Sale`, []bool{false, true, false}},
+		{`discount >= 40`, []bool{true, false, false}},
+		{`discount > 40`, []bool{false, false, false}},
+		{`traitDiscount >= 50`, []bool{true, false, false}},
+		{`TRAITDISCOUNT < 50`, []bool{false, false, false}},
+		// tokens not ranked are not rare
+		{`rarity <= 5`, []bool{true, false, false}},
+		{`rarity < 10`, []bool{true, true, false}},
+		{`rarity < 5 or rank > 1000`, []bool{true, false, false}},
+		{`rank <= 900 and not rank < 900`, []bool{false, true, false}},
+	}
+	for i, tt := range tests {
+		p, err := compileExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		for j, r := range []Record{cheap, dear, mint} {
+			if got := p.eval(&r); got != tt.want[j] {
+				t.Errorf("%d: record %d = %v", i, j, got)
+			}
+		}
+	}
+}
+
+func TestExprError(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{``, "1:1: empty expression"},
+		{`colour = red`, "1:1: unknown field colour"},
+		{`event = Sold`, "1:9: unknown event Sold"},
+		{`event in (Sale, 1)`, "1:17: unknown event 1"},
+		{`event < Sale`, "1:7: operator < is not supported by event"},
+		{`price < cheap`, "1:9: price wants a number, got `cheap`"},
+		{`price in (1, 2)`, "1:7: operator in is not supported by price"},
+		{`price.max < 1`, "1:7: price has no field max"},
+		{`discount > 20 ETH`, "1:15: unexpected `ETH` after the discount"},
+		{`rarity <= 5 ETH`, "1:13: unexpected `ETH` after the rarity"},
+		{`trait = Gold`, "1:1: trait wants a trait type, like trait.Background"},
+		{`trait.Level = 3 ETH`, "1:17: unexpected `ETH` after the trait value"},
+		{`wallet = alice`, "1:10: alice is not an address"},
+		{`event = Sale and`, "1:17: unexpected end of expression, want a field"},
+		{`event = Sale price < 1`, "1:14: unexpected `price`, want and, or"},
+		{`(event = Sale`, "1:14: unexpected end of expression, want `)`"},
+		{`event in (Sale List)`, "1:16: unexpected `List`, want `,` or `)`"},
+		{`event not Sale`, "1:11: unexpected `Sale`, want in"},
+		{`event ! Sale`, "1:7: unknown operator !, want !="},
+		{`trait.Name = "Cat`, "1:14: string not terminated"},
+		{`price < 1.2.3`, "1:9: 1.2.3 is not a number"},
+		{`price < 0.5 ETHH`, "1:13: unknown currency `ETHH`"},
+		{`price<1e5`, "1:8: unknown currency `e5`"},
+		{`price >= 2 usdt`, "1:12: unknown currency `usdt`"},
+		{`price < 1 and
+  event @ Sale`, "2:9: unexpected character '@'"},
+	}
+	for i, tt := range tests {
+		err := CheckFilter(tt.expr)
+		var e *ExprError
+		if !errors.As(err, &e) || err.Error() != tt.want {
+			t.Errorf("%d: error = %v, want %q", i, err, tt.want)
+		}
+	}
+}
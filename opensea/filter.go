@@ -0,0 +1,445 @@
+package opensea
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strings"
+)
+
+// Filter fields of Configuration.Filter.
+const (
+	FilterEvent      = "event"      // event type or list of types
+	FilterWallet     = "wallet"     // address or list of addresses, the seller/sender or buyer/receiver
+	FilterPrice      = "price"      // {"min": 0.1, "max": 1, "currency": "ETH"} or list of ranges
+	FilterProperties = "properties" // {"Background": "Gold", "Eyes": ["Laser", "Blue"]} or list of them
+	FilterTraits     = "traits"     // same as properties
+	FilterDiscount   = "discount"   // {"min": 20}, percent of a listing below the floor price
+	FilterRarity     = "rarity"     // {"max": 5}, the rarity rank in percent of the collection, 5 is the rarest 5%
+	FilterRank       = "rank"       // {"max": 100}, the rarity rank
+	// FilterTraitDiscount is the percent of a listing below the trait floor price, like discount.
+	FilterTraitDiscount = "traitDiscount"
+
+	// defaultCurrency is the currency of a price range without currency, the ETH value of the price.
+	defaultCurrency = "ETH"
+)
+
+var knownEvents = map[string]bool{
+	EventSale:       true,
+	EventOffer:      true,
+	EventBid:        true,
+	EventBidCancel:  true,
+	EventListCancel: true,
+	EventTransfer:   true,
+	EventMint:       true,
+	EventList:       true,
+}
+
+// predicate is a compiled filter, evaluated on every record delivered to the chat.
+type predicate interface {
+	eval(r *Record) bool
+}
+
+// anyOf passes if any one passes, a list in the filter.
+type anyOf []predicate
+
+func (p anyOf) eval(r *Record) bool {
+	for _, c := range p {
+		if c.eval(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// allOf passes if all pass, a map in the filter.
+type allOf []predicate
+
+func (p allOf) eval(r *Record) bool {
+	for _, c := range p {
+		if !c.eval(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// negation passes if the predicate fails, `not` and `!=` of an expression.
+type negation struct {
+	p predicate
+}
+
+func (p negation) eval(r *Record) bool {
+	return !p.p.eval(r)
+}
+
+type eventIs string
+
+func (p eventIs) eval(r *Record) bool {
+	return r.Event == string(p)
+}
+
+// walletIs is a checksum address taking part in the event.
+type walletIs string
+
+func (p walletIs) eval(r *Record) bool {
+	return r.FromAddress == string(p) || r.ToAddress == string(p)
+}
+
+// Comparison operators of priceCmp.
+const (
+	opLT = "<"
+	opLE = "<="
+	opGT = ">"
+	opGE = ">="
+	opEQ = "="
+	opNE = "!="
+)
+
+// priceCmp compares the price in the currency, records without price in the currency don't pass.
+type priceCmp struct {
+	currency string
+	op       string
+	value    decimal.Decimal
+}
+
+func (p priceCmp) eval(r *Record) bool {
+	price := r.priceIn(p.currency)
+	if price == nil {
+		return false
+	}
+	return compare(price.Cmp(p.value), p.op)
+}
+
+// discountCmp compares the discount of a listing to the floor price, or to the trait floor price.
+// Other records don't pass.
+type discountCmp struct {
+	trait bool
+	op    string
+	value decimal.Decimal
+}
+
+func (p discountCmp) eval(r *Record) bool {
+	d, ok := r.discount()
+	if p.trait {
+		d, ok = r.traitDiscount()
+	}
+	if !ok {
+		return false
+	}
+	return compare(d.Cmp(p.value), p.op)
+}
+
+// rarityCmp compares the rarity rank in percent, or the rank. Tokens not ranked don't pass.
+type rarityCmp struct {
+	rank  bool
+	op    string
+	value decimal.Decimal
+}
+
+func (p rarityCmp) eval(r *Record) bool {
+	if r.Rarity == nil {
+		return false
+	}
+	v := r.Rarity.percentile()
+	if p.rank {
+		v = decimal.NewFromInt(int64(r.Rarity.Rank))
+	}
+	return compare(v.Cmp(p.value), p.op)
+}
+
+// rangeCmp returns the predicate comparing the number of the field, discount, traitDiscount, rarity or rank.
+func rangeCmp(field, op string, d decimal.Decimal) predicate {
+	switch field {
+	case FilterRarity, FilterRank:
+		return rarityCmp{rank: field == FilterRank, op: op, value: d}
+	default:
+		return discountCmp{trait: field == FilterTraitDiscount, op: op, value: d}
+	}
+}
+
+// compare returns the result of the operator by the result of Cmp.
+func compare(c int, op string) bool {
+	switch op {
+	case opLT:
+		return c < 0
+	case opLE:
+		return c <= 0
+	case opGT:
+		return c > 0
+	case opGE:
+		return c >= 0
+	case opNE:
+		return c != 0
+	default:
+		return c == 0
+	}
+}
+
+// traitIs matches a trait of the token, case-insensitive.
+type traitIs struct {
+	typ   string
+	value string
+}
+
+func (p traitIs) eval(r *Record) bool {
+	for _, t := range r.Traits {
+		if strings.EqualFold(t.Type, p.typ) && strings.EqualFold(t.Value, p.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// priceIn returns the price in the currency: ETH and USD are the values of the price,
+// other currencies are the symbol of the payment token. Nil if unknown.
+func (r *Record) priceIn(currency string) *Decimal {
+	switch strings.ToUpper(currency) {
+	case "ETH":
+		return r.ETH
+	case "USD":
+		return r.USD
+	}
+	if r.Price != nil && strings.EqualFold(r.Payment.Symbol, currency) {
+		return r.Price
+	}
+	return nil
+}
+
+// knownCurrency returns true for ETH, USD and the symbols of the known payment tokens,
+// a price in another currency never matches.
+func knownCurrency(currency string) bool {
+	switch strings.ToUpper(currency) {
+	case "ETH", "USD":
+		return true
+	}
+	for _, t := range paymentTokens {
+		if strings.EqualFold(t.Symbol, currency) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileFilter compiles the filter of a chat, nil if the chat has no filter.
+// The top level is a list, a list means `or` and a map means `and`, the same for field values.
+// A string is a filter expression, see compileExpr.
+// The filter is decoded from BSON, documents and arrays (primitive.D, primitive.A) are accepted.
+func compileFilter(filter []interface{}) (predicate, error) {
+	if len(filter) == 0 {
+		return nil, nil
+	}
+	return compileNode("filter", filter)
+}
+
+func compileNode(path string, v interface{}) (predicate, error) {
+	switch n := normalize(v).(type) {
+	case []interface{}:
+		if len(n) == 0 {
+			return nil, fmt.Errorf("%s: empty list", path)
+		}
+		or := make(anyOf, 0, len(n))
+		for i, e := range n {
+			p, err := compileNode(fmt.Sprintf("%s[%d]", path, i), e)
+			if err != nil {
+				return nil, err
+			}
+			or = append(or, p)
+		}
+		return or, nil
+	case map[string]interface{}:
+		and := make(allOf, 0, len(n))
+		for _, k := range sortedFields(n) {
+			p, err := compileField(path+"."+k, k, n[k])
+			if err != nil {
+				return nil, err
+			}
+			and = append(and, p)
+		}
+		return and, nil
+	case string:
+		p, err := compileExpr(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("%s: want a list, a map or an expression, got %T", path, v)
+	}
+}
+
+func compileField(path, field string, v interface{}) (predicate, error) {
+	switch field {
+	case FilterEvent:
+		return compileValues(path, v, func(path string, v interface{}) (predicate, error) {
+			e, ok := v.(string)
+			if !ok || !knownEvents[e] {
+				return nil, fmt.Errorf("%s: unknown event %v", path, v)
+			}
+			return eventIs(e), nil
+		})
+	case FilterWallet:
+		return compileValues(path, v, func(path string, v interface{}) (predicate, error) {
+			a, ok := v.(string)
+			if !ok || !common.IsHexAddress(a) {
+				return nil, fmt.Errorf("%s: %v is not an address", path, v)
+			}
+			return walletIs(common.HexToAddress(a).Hex()), nil
+		})
+	case FilterPrice:
+		return compileValues(path, v, compilePrice)
+	case FilterProperties, FilterTraits:
+		return compileValues(path, v, compileTraits)
+	case FilterDiscount, FilterTraitDiscount, FilterRarity, FilterRank:
+		return compileValues(path, v, func(path string, v interface{}) (predicate, error) {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: want a %s range, got %T", path, field, v)
+			}
+			return compileRange(path, field, m, func(op string, d decimal.Decimal) predicate {
+				return rangeCmp(field, op, d)
+			})
+		})
+	default:
+		return nil, fmt.Errorf("%s: unknown field %s", path, field)
+	}
+}
+
+// compileValues compiles a value, or a list of values as `or`.
+func compileValues(path string, v interface{}, compile func(path string, v interface{}) (predicate, error)) (predicate, error) {
+	list, ok := normalize(v).([]interface{})
+	if !ok {
+		return compile(path, normalize(v))
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("%s: empty list", path)
+	}
+	or := make(anyOf, 0, len(list))
+	for i, e := range list {
+		p, err := compile(fmt.Sprintf("%s[%d]", path, i), normalize(e))
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, p)
+	}
+	return or, nil
+}
+
+// compilePrice compiles a price range {"min": 0.1, "max": 1, "currency": "ETH"}, both bounds included.
+func compilePrice(path string, v interface{}) (predicate, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: want a price range, got %T", path, v)
+	}
+	currency := defaultCurrency
+	if c, ok := m["currency"]; ok {
+		if currency, ok = c.(string); !ok || currency == "" {
+			return nil, fmt.Errorf("%s.currency: %v is not a currency", path, c)
+		}
+		if !knownCurrency(currency) {
+			return nil, fmt.Errorf("%s.currency: unknown currency %s", path, currency)
+		}
+	}
+	return compileRange(path, FilterPrice, m, func(op string, d decimal.Decimal) predicate {
+		return priceCmp{currency: currency, op: op, value: d}
+	})
+}
+
+// compileRange compiles the min and max of a range, both included. The currency of a price is skipped.
+func compileRange(path, name string, m map[string]interface{}, cmp func(op string, d decimal.Decimal) predicate) (predicate, error) {
+	var and allOf
+	for _, k := range sortedFields(m) {
+		var op string
+		switch {
+		case k == "currency" && name == FilterPrice:
+			continue
+		case k == "min":
+			op = opGE
+		case k == "max":
+			op = opLE
+		default:
+			return nil, fmt.Errorf("%s: unknown %s field %s", path, name, k)
+		}
+		d, err := toDecimal(m[k])
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", path, k, err)
+		}
+		and = append(and, cmp(op, d))
+	}
+	if len(and) == 0 {
+		return nil, fmt.Errorf("%s: %s range without min or max", path, name)
+	}
+	return and, nil
+}
+
+// compileTraits compiles {"Background": "Gold", "Eyes": ["Laser", "Blue"]}, every trait type is matched.
+func compileTraits(path string, v interface{}) (predicate, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		return nil, fmt.Errorf("%s: want a map of traits, got %T", path, v)
+	}
+	and := make(allOf, 0, len(m))
+	for _, typ := range sortedFields(m) {
+		p, err := compileValues(path+"."+typ, m[typ], func(path string, v interface{}) (predicate, error) {
+			switch v.(type) {
+			case string, int32, int64, int, float64:
+				return traitIs{typ: typ, value: fmt.Sprint(v)}, nil
+			default:
+				return nil, fmt.Errorf("%s: %T is not a trait value", path, v)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, p)
+	}
+	return and, nil
+}
+
+// normalize converts the documents and arrays decoded from BSON to maps and slices.
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case primitive.D:
+		m := make(map[string]interface{}, len(n))
+		for _, e := range n {
+			m[e.Key] = e.Value
+		}
+		return m
+	case primitive.M:
+		return map[string]interface{}(n)
+	case primitive.A:
+		return []interface{}(n)
+	default:
+		return v
+	}
+}
+
+func toDecimal(v interface{}) (decimal.Decimal, error) {
+	switch n := v.(type) {
+	case int32:
+		return decimal.NewFromInt32(n), nil
+	case int64:
+		return decimal.NewFromInt(n), nil
+	case int:
+		return decimal.NewFromInt(int64(n)), nil
+	case float64:
+		return decimal.NewFromFloat(n), nil
+	case string:
+		return decimal.NewFromString(n)
+	case primitive.Decimal128:
+		return decimal.NewFromString(n.String())
+	default:
+		return decimal.Zero, fmt.Errorf("%v is not a number", v)
+	}
+}
+
+func sortedFields(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,101 @@
+package opensea
+
+import (
+	"github.com/shopspring/decimal"
+	"go.mongodb.org/mongo-driver/bson"
+	"strings"
+	"testing"
+)
+
+// decodeChat decodes the chat like LoadChats, the filter is in primitive.D and primitive.A.
+func decodeChat(t *testing.T, filter bson.A) Configuration {
+	t.Helper()
+	data, err := bson.Marshal(bson.D{{"chatId", 1}, {"filter", filter}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chat Configuration
+	if err = bson.Unmarshal(data, &chat); err != nil {
+		t.Fatal(err)
+	}
+	return chat
+}
+
+func TestFilter(t *testing.T) {
+	eth := func(s string) *Decimal {
+		return NewDecimal(decimal.RequireFromString(s))
+	}
+	alice := "0x1A92f7381B9F03921564a437210bB9396471050C"
+	gold := []Trait{{Type: "Background", Value: "Gold"}, {Type: "Eyes", Value: "Laser"}}
+	cheap := Record{Event: EventList, Price: eth("0.3"), ETH: eth("0.3"), Payment: Token{Symbol: "ETH"}, Traits: gold, Floor: eth("0.5"),
+		Rarity: &Rarity{Rank: 123, Total: 10000}}
+	dear := Record{Event: EventSale, Price: eth("3000"), ETH: eth("2"), USD: eth("3000"), Payment: Token{Symbol: "USDC"}, FromAddress: alice}
+	mint := Record{Event: EventMint, ToAddress: alice}
+
+	tests := []struct {
+		filter bson.A
+		want   []bool // cheap, dear, mint
+	}{
+		{nil, []bool{true, true, true}},
+		{bson.A{bson.D{{"event", "Mint"}}}, []bool{false, false, true}},
+		// list is or
+		{bson.A{bson.D{{"event", bson.A{"List", "Sale"}}}}, []bool{true, true, false}},
+		// ETH value by default
+		{bson.A{bson.D{{"price", bson.D{{"max", 0.5}}}}}, []bool{true, false, false}},
+		{bson.A{bson.D{{"price", bson.D{{"min", "1"}, {"max", int32(2)}}}}}, []bool{false, true, false}},
+		{bson.A{bson.D{{"price", bson.D{{"min", 2500}, {"currency", "USD"}}}}}, []bool{false, true, false}},
+		{bson.A{bson.D{{"price", bson.D{{"max", 5000}, {"currency", "usdc"}}}}}, []bool{false, true, false}},
+		// map is and
+		{bson.A{bson.D{{"event", "List"}, {"price", bson.D{{"min", 1}}}}}, []bool{false, false, false}},
+		{bson.A{bson.D{{"properties", bson.D{{"background", "gold"}, {"Eyes", bson.A{"Blue", "Laser"}}}}}}, []bool{true, false, false}},
+		{bson.A{bson.D{{"traits", bson.D{{"Background", "Gold"}, {"Eyes", "Blue"}}}}}, []bool{false, false, false}},
+		{bson.A{bson.D{{"wallet", strings.ToLower(alice)}}}, []bool{false, true, true}},
+		{bson.A{bson.D{{"discount", bson.D{{"min", 20}}}}}, []bool{true, false, false}},
+		{bson.A{bson.D{{"rarity", bson.D{{"max", 5}}}}}, []bool{true, false, false}},
+		{bson.A{bson.D{{"rank", bson.D{{"min", 200}}}}}, []bool{false, false, false}},
+		// top level is or
+		{bson.A{bson.D{{"event", "Mint"}}, bson.D{{"price", bson.D{{"max", 0.5}}}}}, []bool{true, false, true}},
+		// expressions and maps are mixed
+		{bson.A{"event = Mint", bson.D{{"price", bson.D{{"min", 1}}}}}, []bool{false, true, true}},
+	}
+	for i, tt := range tests {
+		chat := decodeChat(t, tt.filter)
+		if err := chat.Compile(); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		for j, r := range []Record{cheap, dear, mint} {
+			if got := chat.Wants(r); got != tt.want[j] {
+				t.Errorf("%d: record %d wanted = %v", i, j, got)
+			}
+		}
+	}
+}
+
+func TestFilterError(t *testing.T) {
+	tests := []struct {
+		filter bson.A
+		want   string
+	}{
+		{bson.A{1}, "filter[0]: want a list, a map or an expression"},
+		{bson.A{"Sale"}, "filter[0]: 1:5: unexpected end of expression, want an operator"},
+		{bson.A{bson.D{{"event", "Sold"}}}, "filter[0].event: unknown event Sold"},
+		{bson.A{bson.D{{"event", bson.A{"Sale", 1}}}}, "filter[0].event[1]: unknown event 1"},
+		{bson.A{bson.D{{"colour", "red"}}}, "filter[0].colour: unknown field colour"},
+		{bson.A{bson.D{{"wallet", "alice"}}}, "filter[0].wallet: alice is not an address"},
+		{bson.A{bson.D{{"price", bson.D{{"max", "cheap"}}}}}, "filter[0].price.max"},
+		{bson.A{bson.D{{"price", bson.D{{"currency", "ETH"}}}}}, "filter[0].price: price range without min or max"},
+		{bson.A{bson.D{{"price", bson.D{{"below", 1}}}}}, "filter[0].price: unknown price field below"},
+		{bson.A{bson.D{{"price", bson.D{{"max", 1}, {"currency", "ETHH"}}}}}, "filter[0].price.currency: unknown currency ETHH"},
+		{bson.A{bson.D{{"discount", bson.D{{"currency", "ETH"}}}}}, "filter[0].discount: unknown discount field currency"},
+		{bson.A{bson.D{{"rarity", 5}}}, "filter[0].rarity: want a rarity range, got int32"},
+		{bson.A{bson.D{{"properties", bson.D{{"Eyes", bson.A{}}}}}}, "filter[0].properties.Eyes: empty list"},
+		{bson.A{bson.D{{"properties", "Gold"}}}, "filter[0].properties: want a map of traits"},
+	}
+	for i, tt := range tests {
+		chat := decodeChat(t, tt.filter)
+		err := chat.Compile()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%d: error = %v, want %q", i, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package opensea
+
+import (
+	"context"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFloorTTL = time.Minute * 10
+	// floorRetry is how long a failed fetch is cached, not to load the shared limiter during an API outage.
+	floorRetry = time.Minute
+)
+
+// FloorConf is the `floor` section, the floor prices of the projects are kept for below-floor (snipe) alerts.
+type FloorConf struct {
+	Enabled bool   `json:"enabled"`
+	TTL     string `json:"ttl"` // how long a floor price is used before fetched again, default 10m
+}
+
+// floorFetcher fetches the floor price of the collection in ETH.
+type floorFetcher func(ctx context.Context, slug string) (decimal.Decimal, error)
+
+// statsFloor fetches the floor price from the collection stats of OpenSea.
+func statsFloor(client *api.Client) floorFetcher {
+	return func(ctx context.Context, slug string) (decimal.Decimal, error) {
+		stats, err := client.CollectionStats(ctx, slug)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		return decimal.NewFromFloat(stats.FloorPrice), nil
+	}
+}
+
+type floor struct {
+	price decimal.Decimal // the last known one, zero if none
+	next  time.Time       // when to fetch again
+}
+
+// floorBook keeps the floor prices of the collections by slug, each one is fetched again after ttl,
+// or after floorRetry if the fetch failed.
+type floorBook struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	fetch  floorFetcher
+	floors map[string]floor
+	Sugar  *zap.SugaredLogger
+}
+
+func newFloorBook(ttl time.Duration, fetch floorFetcher, sugar *zap.SugaredLogger) *floorBook {
+	return &floorBook{ttl: ttl, fetch: fetch, floors: make(map[string]floor), Sugar: sugar}
+}
+
+// get returns the floor price of the collection, fetched if unknown or due.
+// The last known one is used if the fetch failed, false if none or the collection has no listing.
+func (b *floorBook) get(ctx context.Context, slug string) (decimal.Decimal, bool) {
+	b.mu.Lock()
+	f, ok := b.floors[slug]
+	b.mu.Unlock()
+	if now := time.Now(); !ok || !now.Before(f.next) {
+		price, err := b.fetch(ctx, slug)
+		if err != nil {
+			b.Sugar.Errorf("fetch floor price of %s error: %s", slug, err)
+			f.next = now.Add(floorRetry)
+		} else {
+			f = floor{price: price, next: now.Add(b.ttl)}
+		}
+		b.mu.Lock()
+		b.floors[slug] = f
+		b.mu.Unlock()
+	}
+	return f.price, f.price.IsPositive()
+}
+
+// stamp sets the floor price on the listings valued in ETH,
+// slug is the collection of the records without slug, e.g. from LooksRare.
+func (b *floorBook) stamp(ctx context.Context, records []Record, slug string) {
+	floors := make(map[string]*Decimal)
+	for i := range records {
+		r := &records[i]
+		if r.Event != EventList || r.ETH == nil {
+			continue
+		}
+		s := r.Slug
+		if s == "" {
+			s = slug
+		}
+		if s == "" {
+			continue
+		}
+		f, ok := floors[s]
+		if !ok {
+			if price, known := b.get(ctx, s); known {
+				f = NewDecimal(price)
+			}
+			floors[s] = f
+		}
+		r.Floor = f
+	}
+}
+
+// discount is how far the listing is below the floor price in percent, e.g. 20 for 80% of the floor,
+// negative if above the floor. False if not a listing or the floor is unknown.
+func (r *Record) discount() (decimal.Decimal, bool) {
+	return r.discountTo(r.Floor)
+}
+
+// traitDiscount is how far the listing is below the trait floor price in percent, like discount.
+func (r *Record) traitDiscount() (decimal.Decimal, bool) {
+	if r.TraitFloor == nil {
+		return decimal.Zero, false
+	}
+	return r.discountTo(r.TraitFloor.Price)
+}
+
+func (r *Record) discountTo(floor *Decimal) (decimal.Decimal, bool) {
+	if r.Event != EventList || r.ETH == nil || floor == nil || !floor.IsPositive() {
+		return decimal.Zero, false
+	}
+	hundred := decimal.New(100, 0)
+	return hundred.Sub(r.ETH.Mul(hundred).Div(floor.Decimal)), true
+}
+
+// below returns true if the discount is more than 100 - percent, the price is below percent of the floor.
+func below(d decimal.Decimal, ok bool, percent float64) bool {
+	return ok && percent > 0 && d.GreaterThan(decimal.NewFromFloat(100-percent))
+}
+
+// initFloors creates the floor book.
+func (s *OpenSea) initFloors() error {
+	ttl := defaultFloorTTL
+	if s.cfg.Floor.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(s.cfg.Floor.TTL); err != nil {
+			return fmt.Errorf("floor ttl %s format error: %w", s.cfg.Floor.TTL, err)
+		}
+	}
+	client, err := s.openSeaClient()
+	if err != nil {
+		return err
+	}
+	s.floors = newFloorBook(ttl, statsFloor(client), s.Sugar)
+	return nil
+}
+
+// openSeaClient returns the client of the opensea source if any, sharing its rate limit,
+// otherwise a client of the global `api` config.
+func (s *OpenSea) openSeaClient() (*api.Client, error) {
+	for _, source := range s.sources {
+		if o, ok := source.(*openSeaSource); ok {
+			return o.client, nil
+		}
+	}
+	return api.New(s.cfg.API)
+}
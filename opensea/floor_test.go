@@ -0,0 +1,136 @@
+package opensea
+
+import (
+	"context"
+	"errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFloorBook(t *testing.T) {
+	var fetched []string
+	var fail bool
+	book := newFloorBook(time.Hour, func(ctx context.Context, slug string) (decimal.Decimal, error) {
+		fetched = append(fetched, slug)
+		if fail {
+			return decimal.Zero, errors.New("unavailable")
+		}
+		if slug == "empty" {
+			return decimal.Zero, nil
+		}
+		return decimal.RequireFromString("1.25"), nil
+	}, zap.NewNop().Sugar())
+	eth := NewDecimal(decimal.RequireFromString("1"))
+	records := []Record{
+		{Event: EventList, Slug: "a", ETH: eth},
+		{Event: EventList, Slug: "a", ETH: eth},
+		{Event: EventSale, Slug: "a", ETH: eth},
+		{Event: EventList, Slug: "a"}, // no ETH value
+		{Event: EventList, ETH: eth},  // LooksRare, the slug of the target
+		{Event: EventList, Slug: "empty", ETH: eth},
+	}
+	book.stamp(context.Background(), records, "b")
+	if strings.Join(fetched, ",") != "a,b,empty" {
+		t.Errorf("fetched = %v", fetched)
+	}
+	for i, want := range []string{"1.25", "1.25", "", "", "1.25", ""} {
+		got := ""
+		if records[i].Floor != nil {
+			got = records[i].Floor.String()
+		}
+		if got != want {
+			t.Errorf("%d: floor = %q, want %q", i, got, want)
+		}
+	}
+
+	// cached within ttl
+	book.stamp(context.Background(), records[:1], "")
+	if len(fetched) != 3 {
+		t.Errorf("fetched again: %v", fetched)
+	}
+	// the last known floor is used if the fetch fails
+	f := book.floors["a"]
+	f.next = time.Now()
+	book.floors["a"] = f
+	fail = true
+	if price, ok := book.get(context.Background(), "a"); !ok || price.String() != "1.25" {
+		t.Errorf("floor = %s, %v", price, ok)
+	}
+	if _, ok := book.get(context.Background(), "c"); ok || len(fetched) != 5 {
+		t.Errorf("unknown floor %v, fetched = %v", ok, fetched)
+	}
+	// the failures are cached for retry
+	book.get(context.Background(), "a")
+	book.get(context.Background(), "c")
+	if len(fetched) != 5 {
+		t.Errorf("fetched again after failure: %v", fetched)
+	}
+	f = book.floors["c"]
+	f.next = time.Now()
+	book.floors["c"] = f
+	fail = false
+	if price, ok := book.get(context.Background(), "c"); !ok || price.String() != "1.25" || len(fetched) != 6 {
+		t.Errorf("floor = %s, %v, fetched = %v", price, ok, fetched)
+	}
+}
+
+func TestSnipe(t *testing.T) {
+	d := func(s string) *Decimal {
+		return NewDecimal(decimal.RequireFromString(s))
+	}
+	contract := "0x1A92f7381B9F03921564a437210bB9396471050C"
+	listing := func(eth string) Record {
+		return Record{Event: EventList, Contract: contract, Slug: "a", Price: d(eth), ETH: d(eth), Payment: Token{Symbol: "ETH"}, Floor: d("2")}
+	}
+	chat := Configuration{Projects: []ProjectConf{{Slug: "a"}}, EventTypes: []string{EventSale}, Snipe: 80}
+	if err := chat.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	other := listing("1")
+	other.Slug, other.Contract = "b", "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"
+	noFloor := listing("1")
+	noFloor.Floor = nil
+	tests := []struct {
+		r    Record
+		tag  string
+		want bool
+	}{
+		{listing("1.5"), "[低于地板价 25%]", true},
+		{listing("1.6"), "[低于地板价 20%]", false}, // 80% of the floor is not below
+		{listing("2.5"), "", false},
+		{other, "[低于地板价 50%]", false},
+		{noFloor, "", false},
+		{Record{Event: EventSale, Contract: contract, Slug: "a", ETH: d("1"), Floor: d("2")}, "", true},
+	}
+	for i, tt := range tests {
+		if got := snipeText(tt.r); got != tt.tag {
+			t.Errorf("%d: tag = %q, want %q", i, got, tt.tag)
+		}
+		if got := chat.Wants(tt.r); got != tt.want {
+			t.Errorf("%d: wanted = %v", i, got)
+		}
+	}
+	if text := Format(listing("1.5"), nil); !strings.HasPrefix(text, "[低于地板价 25%]\n") || !strings.Contains(text, "地板价: 2 ETH") {
+		t.Errorf("format = %s", text)
+	}
+
+	// below the trait floor
+	rare := listing("2.5")
+	rare.TraitFloor = &TraitFloor{Type: "Background", Value: "Gold", Price: d("5")}
+	rare.Traits = []Trait{{Type: "Background", Value: "Gold"}}
+	if chat.Wants(rare) {
+		t.Error("trait floor is not sniped")
+	}
+	chat.TraitSnipe = 60
+	if !chat.Wants(rare) {
+		t.Error("below the trait floor is not wanted")
+	}
+	text := Format(rare, map[string]bool{OptionProperties: true})
+	if !strings.HasPrefix(text, "[低于属性地板价 50%: Background Gold]\n") ||
+		!strings.Contains(text, "属性地板价: Background Gold 5 ETH") || !strings.Contains(text, "属性: Background: Gold") {
+		t.Errorf("format = %s", text)
+	}
+}
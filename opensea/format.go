@@ -0,0 +1,174 @@
+package opensea
+
+import (
+	"fmt"
+	"github.com/xyths/hs/convert"
+	"strings"
+	"time"
+)
+
+// Format is for Telegram text message.
+func Format(record Record, options map[string]bool) string {
+	link := options[OptionLink]
+	properties := options[OptionProperties]
+	from := accountText(record.FromName, record.FromAddress)
+	to := accountText(record.ToName, record.ToAddress)
+	price := priceText(record)
+	content := fmt.Sprintf("项目: %s\n名称: %s\nTokenId: %s", record.Collection, record.Name, record.Id)
+	switch record.Event {
+	case EventSale:
+		content += fmt.Sprintf(
+			` 成交(Sale)
+  买家: %s
+  卖家: %s
+  价格: %s`,
+			to, from, price,
+		)
+		if record.Bundle > 1 {
+			content += fmt.Sprintf("\n  打包成交: %d 个NFT，价格按个数均摊", record.Bundle)
+		}
+	case EventOffer:
+		content += fmt.Sprintf(
+			` 出价(Offer)
+  买家: %s
+  价格: %s`,
+			from, price,
+		)
+	case EventBid:
+		content += fmt.Sprintf(
+			` 出价(Bid)
+  买家: %s
+  价格: %s`,
+			from, price,
+		)
+	case EventBidCancel:
+		content += fmt.Sprintf(
+			` 撤销出价(Bid Cancel)
+  买家: %s
+  价格: %s`,
+			from, price,
+		)
+	case EventListCancel:
+		content += fmt.Sprintf(
+			` 撤销上架(List Cancel)
+  卖家: %s`,
+			from,
+		)
+	case EventTransfer:
+		content += fmt.Sprintf(
+			` 转让(Transfer)
+  发送方: %s
+  接收方: %s`,
+			from, to,
+		)
+	case EventMint:
+		content += fmt.Sprintf(
+			` 铸造完成 (Mint)
+  接收方: %s`,
+			to,
+		)
+	case EventList:
+		content += fmt.Sprintf(
+			` 拍卖(List)
+  卖家: %s
+  价格: %s`,
+			from, price,
+		)
+		if record.Floor != nil {
+			content += fmt.Sprintf("\n  地板价: %s ETH", record.Floor.Round(4).String())
+		}
+		if f := record.TraitFloor; f != nil {
+			content += fmt.Sprintf("\n  属性地板价: %s %s %s ETH", f.Type, f.Value, f.Price.Round(4).String())
+		}
+	default:
+	}
+	if value := valueText(record); value != "" {
+		content += fmt.Sprintf("\n  估值: %s", value)
+	}
+	if properties && len(record.Traits) > 0 {
+		content += fmt.Sprintf("\n  属性: %s", traitsText(record.Traits))
+	}
+	if r := record.Rarity; r != nil {
+		content += fmt.Sprintf("\n  Rarity #%d / %d", r.Rank, r.Total)
+	}
+	if record.Quantity > 1 {
+		content += fmt.Sprintf("\n  数量: %d", record.Quantity)
+	}
+	content += fmt.Sprintf("\n  时间: %s", timeText(record.Time))
+	if tag := snipeText(record); tag != "" {
+		content = tag + "\n" + content
+	}
+	if len(record.Wallets) > 0 {
+		content += fmt.Sprintf("\n追踪钱包: %s", strings.Join(record.Wallets, ", "))
+	}
+	if link {
+		content += fmt.Sprintf("\n地址: https://opensea.io/assets/%s/%s", strings.ToLower(record.Contract), record.Id)
+		if record.Slug != "" {
+			content += fmt.Sprintf("\n项目地址: https://opensea.io/collection/%s", record.Slug)
+		}
+		content += fmt.Sprintf("\n预览图片: \n%s", record.ImagePreviewUrl)
+	}
+	return content
+}
+
+// priceText is the price with its token, e.g. "1.5 ETH", empty for events without price.
+func priceText(r Record) string {
+	if r.Price == nil {
+		return ""
+	}
+	return r.Price.String() + " " + r.Payment.Symbol
+}
+
+// valueText is the ETH and USD values of the price, e.g. "0.8 ETH / $2400.12", empty if both unknown.
+func valueText(r Record) string {
+	var values []string
+	if r.ETH != nil {
+		values = append(values, r.ETH.Round(4).String()+" ETH")
+	}
+	if r.USD != nil {
+		values = append(values, "$"+r.USD.StringFixed(2))
+	}
+	return strings.Join(values, " / ")
+}
+
+// snipeText tags a listing below the floor price and the trait floor price with the discounts,
+// e.g. "[低于地板价 20%] [低于属性地板价 35%: Background Gold]", empty if not below.
+func snipeText(r Record) string {
+	var tags []string
+	if d, ok := r.discount(); ok && d.IsPositive() {
+		tags = append(tags, fmt.Sprintf("[低于地板价 %s%%]", d.Round(1).String()))
+	}
+	if d, ok := r.traitDiscount(); ok && d.IsPositive() {
+		tags = append(tags, fmt.Sprintf("[低于属性地板价 %s%%: %s %s]", d.Round(1).String(), r.TraitFloor.Type, r.TraitFloor.Value))
+	}
+	return strings.Join(tags, " ")
+}
+
+// traitsText is the traits of the token, e.g. "Background: Gold, Eyes: Laser".
+func traitsText(traits []Trait) string {
+	texts := make([]string, 0, len(traits))
+	for _, t := range traits {
+		texts = append(texts, t.Type+": "+t.Value)
+	}
+	return strings.Join(texts, ", ")
+}
+
+// accountText is the user name with the short address, or only the short address if no name.
+func accountText(name, address string) string {
+	if address == "" {
+		return ""
+	}
+	short := convert.ShortAddress(address)
+	if name != "" {
+		return fmt.Sprintf("%s(%s)", name, short)
+	}
+	return short
+}
+
+// timeText is the local time of day of the event, empty if unknown.
+func timeText(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return localTime(t)
+}
@@ -0,0 +1,218 @@
+package opensea
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.uber.org/zap"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	SourceTypeLooksRare = "looksrare"
+
+	defaultLooksRareURL = "https://api.looksrare.org"
+	looksRarePageLimit  = 150
+	looksRareKeyHeader  = "X-Looks-Api-Key"
+)
+
+// looksRareEvents maps the event type of LooksRare to the event of Record.
+var looksRareEvents = map[string]string{
+	"MINT":         EventMint,
+	"TRANSFER":     EventTransfer,
+	"LIST":         EventList,
+	"SALE":         EventSale,
+	"OFFER":        EventOffer,
+	"CANCEL_OFFER": EventBidCancel,
+	"CANCEL_LIST":  EventListCancel,
+}
+
+type looksRareResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message"`
+	Data    []looksRareEvent `json:"data"`
+}
+
+type looksRareEvent struct {
+	Id         int64  `json:"id"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Type       string `json:"type"`
+	Hash       string `json:"hash"`
+	CreatedAt  string `json:"createdAt"`
+	Collection struct {
+		Address string `json:"address"`
+		Name    string `json:"name"`
+	} `json:"collection"`
+	Token struct {
+		TokenId  string `json:"tokenId"`
+		Name     string `json:"name"`
+		ImageURI string `json:"imageURI"`
+	} `json:"token"`
+	Order *struct {
+		Price           string `json:"price"`
+		Amount          string `json:"amount"`
+		CurrencyAddress string `json:"currencyAddress"`
+	} `json:"order"`
+}
+
+// looksRareSource is the LooksRare events API,
+// it queries by contract (every contract of a collection), token and account.
+type looksRareSource struct {
+	name   string
+	client *api.Client
+	Sugar  *zap.SugaredLogger
+}
+
+func newLooksRareSource(conf SourceConf, sugar *zap.SugaredLogger) (EventSource, error) {
+	if conf.API.BaseURL == "" {
+		conf.API.BaseURL = defaultLooksRareURL
+	}
+	if conf.API.KeyHeader == "" {
+		conf.API.KeyHeader = looksRareKeyHeader
+	}
+	client, err := api.New(conf.API)
+	if err != nil {
+		return nil, err
+	}
+	sugar.Infof("LooksRare API client initialized, endpoint: %s", client.BaseURL())
+	return &looksRareSource{name: conf.Name, client: client, Sugar: sugar}, nil
+}
+
+func (l *looksRareSource) Name() string {
+	return l.name
+}
+
+func (l *looksRareSource) Supports(q Query) bool {
+	return len(q.Contracts) > 0 || q.Account != ""
+}
+
+// Fetch fetches every contract of the collection, or the events from and to the account.
+func (l *looksRareSource) Fetch(ctx context.Context, q Query, from, to time.Time) ([]Record, error) {
+	var queries []url.Values
+	if q.Account != "" {
+		for _, side := range []string{"from", "to"} {
+			v := url.Values{}
+			v.Set(side, q.Account)
+			queries = append(queries, v)
+		}
+	} else {
+		for _, c := range q.Contracts {
+			v := url.Values{}
+			v.Set("collection", c)
+			if q.TokenId != "" {
+				v.Set("tokenId", q.TokenId)
+			}
+			queries = append(queries, v)
+		}
+	}
+	if t := looksRareType(q.Events); t != "" {
+		for _, v := range queries {
+			v.Set("type", t)
+		}
+	}
+	var records []Record
+	ids := make(map[string]bool)
+	for _, v := range queries {
+		events, err := l.fetch(ctx, v, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range events {
+			// an account may be on both sides
+			if !ids[r.EventId] {
+				ids[r.EventId] = true
+				records = append(records, r)
+			}
+		}
+	}
+	return records, nil
+}
+
+// fetch pages the events of the query by cursor, newest first, until the window boundary.
+func (l *looksRareSource) fetch(ctx context.Context, v url.Values, from, to time.Time) ([]Record, error) {
+	var records []Record
+	v.Set("pagination[first]", strconv.Itoa(looksRarePageLimit))
+	for {
+		var resp looksRareResponse
+		if err := l.client.Get(ctx, "/api/v1/events", v, &resp); err != nil {
+			return nil, err
+		}
+		if !resp.Success {
+			return nil, fmt.Errorf("looksrare events %s error: %s", v.Encode(), resp.Message)
+		}
+		for _, e := range resp.Data {
+			t, err := time.Parse(time.RFC3339, e.CreatedAt)
+			if err != nil {
+				return nil, fmt.Errorf("looksrare event %d time %s format error: %w", e.Id, e.CreatedAt, err)
+			}
+			if !t.Before(to) {
+				continue
+			}
+			if t.Before(from) {
+				return records, nil
+			}
+			if r, ok := fromLooksRare(e, t); ok {
+				records = append(records, r)
+			}
+		}
+		if len(resp.Data) < looksRarePageLimit {
+			return records, nil
+		}
+		v.Set("pagination[cursor]", strconv.FormatInt(resp.Data[len(resp.Data)-1].Id, 10))
+	}
+}
+
+func fromLooksRare(e looksRareEvent, t time.Time) (Record, bool) {
+	event, ok := looksRareEvents[e.Type]
+	if !ok {
+		return Record{}, false
+	}
+	r := Record{
+		EventId:         strconv.FormatInt(e.Id, 10),
+		TxHash:          e.Hash,
+		Collection:      e.Collection.Name,
+		Contract:        common.HexToAddress(e.Collection.Address).Hex(),
+		Name:            e.Token.Name,
+		Id:              e.Token.TokenId,
+		Event:           event,
+		Quantity:        1,
+		Time:            t,
+		ImagePreviewUrl: e.Token.ImageURI,
+		CreatedAt:       time.Now(),
+	}
+	if e.From != "" {
+		r.FromAddress = common.HexToAddress(e.From).Hex()
+	}
+	if e.To != "" {
+		r.ToAddress = common.HexToAddress(e.To).Hex()
+	}
+	if e.Order != nil {
+		r.Quantity = quantity(e.Order.Amount)
+		if event != EventMint && event != EventTransfer {
+			r.setPrice(e.Order.Price, paymentToken(common.HexToAddress(e.Order.CurrencyAddress)))
+		}
+	}
+	return r, true
+}
+
+// looksRareType returns the `type` to query when the events are one LooksRare type, otherwise "" (all types).
+func looksRareType(events []string) string {
+	types := make(map[string]bool)
+	for _, e := range events {
+		for t, event := range looksRareEvents {
+			if event == e {
+				types[t] = true
+			}
+		}
+	}
+	if len(types) == 1 {
+		for t := range types {
+			return t
+		}
+	}
+	return ""
+}
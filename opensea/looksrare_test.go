@@ -0,0 +1,106 @@
+package opensea
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// mockLooksRare serves `total` sales one second apart, newest first, paged by the id cursor.
+func mockLooksRare(t *testing.T, total int, newest time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/events" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		limit, _ := strconv.Atoi(q.Get("pagination[first]"))
+		start := 0
+		if c := q.Get("pagination[cursor]"); c != "" {
+			// ids are total, total-1, ...
+			id, _ := strconv.Atoi(c)
+			start = total - id + 1
+		}
+		resp := looksRareResponse{Success: true, Data: []looksRareEvent{}}
+		for i := start; i < start+limit && i < total; i++ {
+			e := looksRareEvent{
+				Id:        int64(total - i),
+				From:      "0x1a92f7381b9f03921564a437210bb9396471050c",
+				To:        q.Get("to"),
+				Type:      "SALE",
+				CreatedAt: newest.Add(-time.Duration(i) * time.Second).UTC().Format("2006-01-02T15:04:05.000Z"),
+			}
+			e.Collection.Address = q.Get("collection")
+			e.Token.TokenId = "7"
+			e.Order = &struct {
+				Price           string `json:"price"`
+				Amount          string `json:"amount"`
+				CurrencyAddress string `json:"currencyAddress"`
+			}{"1500000000000000000", "1", "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"}
+			resp.Data = append(resp.Data, e)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestLooksRareSource(t *testing.T) {
+	newest := time.Date(2022, 1, 10, 12, 0, 0, 0, time.UTC)
+	srv := mockLooksRare(t, 400, newest)
+	defer srv.Close()
+	source, err := newLooksRareSource(SourceConf{Name: "looksrare", API: api.Config{BaseURL: srv.URL, RateLimit: 1000}},
+		zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Supports(Query{Slug: "cool-cats"}) {
+		t.Error("slug only is not supported")
+	}
+	contract := "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"
+	// 12:00:00 ... 11:55:00, 301 events in 3 pages
+	records, err := source.Fetch(context.Background(), Query{Contracts: []string{contract}},
+		newest.Add(-5*time.Minute), newest.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 301 {
+		t.Fatalf("%d records, want 301", len(records))
+	}
+	r := records[0]
+	if r.EventId != "400" || r.Event != EventSale || r.Contract != contract || priceText(r) != "1.5 WETH" ||
+		r.FromAddress != "0x1A92f7381B9F03921564a437210bB9396471050C" {
+		t.Errorf("record = %+v", r)
+	}
+
+	// both sides of the account, the same event once
+	records, err = source.Fetch(context.Background(), Query{Account: "0x1a92f7381b9f03921564a437210bb9396471050c"},
+		newest.Add(-time.Minute), newest.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 61 {
+		t.Errorf("%d account records, want 61", len(records))
+	}
+}
+
+func TestLooksRareType(t *testing.T) {
+	tests := []struct {
+		events []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{EventSale}, "SALE"},
+		{[]string{EventSale, EventList}, ""},
+		{[]string{EventBidCancel}, "CANCEL_OFFER"},
+		{[]string{EventBid}, ""},
+	}
+	for i, tt := range tests {
+		if got := looksRareType(tt.events); got != tt.want {
+			t.Errorf("%d: type = %q, want %q", i, got, tt.want)
+		}
+	}
+}
@@ -2,20 +2,20 @@ package opensea
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/bwmarrin/discordgo"
 	"github.com/ethereum/go-ethereum/common"
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/xyths/hs"
 	"github.com/xyths/hs/broadcast"
+	"github.com/xyths/opensea-monitor/opensea/api"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
-	"net/http"
-	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,6 +27,9 @@ const (
 	keyLastUpdateTime = "lastUpdateTime"
 
 	expireIndexName = "eventExpireIndex"
+	tailIndexName   = "eventTailIndex"
+
+	defaultRetention = time.Hour * 24 * 7
 )
 
 type Discord struct {
@@ -39,8 +42,9 @@ type Discord struct {
 }
 
 type TelegramConf struct {
-	Bot   string // bot username
-	Token string
+	Bot      string // bot username
+	Token    string
+	Interval string // how often the bot reads new events, default 2s
 }
 
 type Config struct {
@@ -50,18 +54,66 @@ type Config struct {
 	Discord  Discord
 	Robots   []hs.BroadcastConf
 	Telegram TelegramConf
+	API      api.Config  `json:"api"`
+	SeenTTL  string      `json:"seenTTL"` // how long an event id is remembered for de-duplication, default 72h
+	CatchUp  CatchUpConf `json:"catchUp"`
+	// Retention is how long events are kept in the events collection for the bots, default 168h.
+	Retention string `json:"retention"`
+	// Workers is the number of projects polled at the same time, default 4.
+	Workers int `json:"workers"`
+	// PollTimeout is the timeout of polling one project, default 1m.
+	PollTimeout string `json:"pollTimeout"`
+	// Schedule makes the polling interval of every project adaptive to its activity.
+	Schedule ScheduleConf `json:"schedule"`
+	// EventTypes are the events (Sale, List, ...) monitored, empty means all.
+	EventTypes []string `json:"eventTypes"`
+	// Sources are the marketplace APIs polled, default OpenSea only.
+	Sources []SourceConf `json:"sources"`
+	// Chain reads transfer logs from an Ethereum node besides OpenSea.
+	Chain ChainConf `json:"chain"`
+	// Stream pushes events of the projects over WebSocket, polling fills the gaps.
+	Stream StreamConf `json:"stream"`
+	// Wallets are tracked for all chats, their events are delivered even for collections not monitored.
+	Wallets []string `json:"wallets"`
+	// Floor keeps the floor prices of the projects, listings are tagged by them for below-floor alerts.
+	Floor FloorConf `json:"floor"`
+	// Traits caches the traits of tokens and keeps the trait floor prices for below-trait-floor alerts.
+	Traits TraitConf `json:"traits"`
+	// Rarity tags the records with the rarity saved by the ranker.
+	Rarity RarityConf `json:"rarity"`
 }
 
 type OpenSea struct {
-	cfg      Config
-	interval time.Duration
-
-	Sugar *zap.SugaredLogger
-	db    *mongo.Database
+	cfg       Config
+	interval  time.Duration
+	seenTTL   time.Duration
+	retention time.Duration
+	catchUp   catchUpPolicy
+
+	workers     int
+	pollTimeout time.Duration
+	schedule    *scheduler
+	eventTypes  eventTypes
+	wallets     wallets // global tracked wallets
+	rates       *rateBook
+	floors      *floorBook  // nil if disabled
+	traits      *traitBook  // nil if disabled
+	rarities    *rarityBook // nil if disabled
+
+	ethClient      *ethclient.Client
+	chain          *ChainSource // nil if disabled
+	chainContracts []common.Address
+
+	stream      *Stream      // nil if disabled
+	streamState atomic.Value // streamState
+
+	Sugar   *zap.SugaredLogger
+	db      *mongo.Database
+	seen    seenStore
+	sources []EventSource
 
 	discord *discordgo.Session
 	robots  []broadcast.Broadcaster
-	tg      *tgbotapi.BotAPI
 }
 
 func New(cfg Config) *OpenSea {
@@ -80,17 +132,116 @@ func (s *OpenSea) Init(ctx context.Context) error {
 		s.Sugar.Errorf("interval %s format error: %s", s.cfg.Interval, err)
 		return err
 	}
+	if s.schedule, err = newScheduler(s.interval, s.cfg.Schedule); err != nil {
+		s.Sugar.Errorf("schedule config error: %s", err)
+		return err
+	}
+	if s.eventTypes, err = newEventTypes(s.cfg.EventTypes); err != nil {
+		s.Sugar.Errorf("eventTypes config error: %s", err)
+		return err
+	}
+	if s.wallets, err = newWallets(s.cfg.Wallets); err != nil {
+		s.Sugar.Errorf("wallets config error: %s", err)
+		return err
+	}
+	if s.catchUp, err = parseCatchUp(s.cfg.CatchUp); err != nil {
+		s.Sugar.Errorf("catch-up config error: %s", err)
+		return err
+	}
+	s.rates = newRateBook()
+	s.seenTTL = defaultSeenTTL
+	if s.cfg.SeenTTL != "" {
+		if s.seenTTL, err = time.ParseDuration(s.cfg.SeenTTL); err != nil {
+			s.Sugar.Errorf("seenTTL %s format error: %s", s.cfg.SeenTTL, err)
+			return err
+		}
+	}
+	s.retention = defaultRetention
+	if s.cfg.Retention != "" {
+		if s.retention, err = time.ParseDuration(s.cfg.Retention); err != nil {
+			s.Sugar.Errorf("retention %s format error: %s", s.cfg.Retention, err)
+			return err
+		}
+	}
+	s.workers = s.cfg.Workers
+	if s.workers <= 0 {
+		s.workers = defaultWorkers
+	}
+	s.pollTimeout = defaultPollTimeout
+	if s.cfg.PollTimeout != "" {
+		if s.pollTimeout, err = time.ParseDuration(s.cfg.PollTimeout); err != nil {
+			s.Sugar.Errorf("pollTimeout %s format error: %s", s.cfg.PollTimeout, err)
+			return err
+		}
+	}
 	db, err := hs.ConnectMongo(ctx, s.cfg.Mongo)
 	if err != nil {
 		s.Sugar.Errorf("connect mongo error: %s", err)
 		return err
 	}
 	s.db = db
+	s.seen = mongoSeenStore{db: db}
 	if err = s.initIndex(ctx); err != nil {
 		s.Sugar.Errorf("init index error: %s", err)
 		return err
 	}
+	if err = s.initWatermarkIndex(ctx); err != nil {
+		s.Sugar.Errorf("init watermark index error: %s", err)
+		return err
+	}
+	if err = s.ensureTTLIndex(ctx, collSeen, seenExpireIndexName, "createdAt", s.seenTTL); err != nil {
+		s.Sugar.Errorf("init seen index error: %s", err)
+		return err
+	}
 	s.Sugar.Info("database initialized")
+	if s.sources, err = newSources(s.cfg.Sources, s.cfg.API, s.Sugar); err != nil {
+		s.Sugar.Errorf("event sources init error: %s", err)
+		return err
+	}
+	if s.cfg.Chain.URL != "" {
+		for _, a := range s.cfg.Chain.Contracts {
+			if !common.IsHexAddress(a) {
+				err = fmt.Errorf("chain contract %s is not an address", a)
+				s.Sugar.Errorf("chain config error: %s", err)
+				return err
+			}
+			s.chainContracts = append(s.chainContracts, common.HexToAddress(a))
+		}
+		if s.ethClient, err = ethclient.DialContext(ctx, s.cfg.Chain.URL); err != nil {
+			s.Sugar.Errorf("dial chain %s error: %s", s.cfg.Chain.URL, err)
+			return err
+		}
+		if s.chain, err = NewChainSource(s.ethClient, s.cfg.Chain, s.Sugar); err != nil {
+			s.Sugar.Errorf("chain config error: %s", err)
+			return err
+		}
+		s.Sugar.Info("chain source initialized")
+	}
+	if s.cfg.Floor.Enabled {
+		if err = s.initFloors(); err != nil {
+			s.Sugar.Errorf("floor config error: %s", err)
+			return err
+		}
+		s.Sugar.Info("floor prices initialized")
+	}
+	if s.cfg.Traits.Enabled {
+		if err = s.initTraits(ctx); err != nil {
+			s.Sugar.Errorf("traits init error: %s", err)
+			return err
+		}
+		s.Sugar.Info("traits initialized")
+	}
+	if s.cfg.Rarity.Enabled {
+		s.rarities = newRarityBook(mongoTraitStore{db: s.db}, s.Sugar)
+		s.Sugar.Info("rarity initialized")
+	}
+	if s.cfg.Stream.URL != "" {
+		if s.stream, err = NewStream(s.cfg.Stream, s.cfg.API.Key, s.Sugar); err != nil {
+			s.Sugar.Errorf("stream config error: %s", err)
+			return err
+		}
+		s.Sugar.Info("stream initialized")
+	}
 	//s.discord, err = discordgo.New("Bot " + s.cfg.Discord.Token)
 	//if err != nil {
 	//	s.Sugar.Errorf("discord bot init error: %s", err)
@@ -99,12 +250,6 @@ func (s *OpenSea) Init(ctx context.Context) error {
 	//for _, conf := range s.cfg.Robots {
 	//	s.robots = append(s.robots, broadcast.New(conf))
 	//}
-	s.tg, err = tgbotapi.NewBotAPI(s.cfg.Telegram.Token)
-	if err != nil {
-		s.Sugar.Errorf("New Telegram bot error: %s", err)
-		return err
-	}
-	s.Sugar.Info("Telegram bot initialized")
 	s.Sugar.Info("OpenSea initialized")
 	return nil
 }
@@ -113,6 +258,9 @@ func (s *OpenSea) Close(ctx context.Context) {
 	//if err := s.discord.Close(); err != nil {
 	//	s.Sugar.Errorf("discord close error: %s", err)
 	//}
+	if s.ethClient != nil {
+		s.ethClient.Close()
+	}
 	if err := s.db.Client().Disconnect(ctx); err != nil {
 		s.Sugar.Errorf("db close error: %s", err)
 	}
@@ -120,6 +268,9 @@ func (s *OpenSea) Close(ctx context.Context) {
 }
 
 func (s *OpenSea) Monitor(ctx context.Context) error {
+	if s.stream != nil {
+		go s.stream.Run(ctx, s.handleStream)
+	}
 	if err := s.doWork(ctx); err != nil {
 		s.Sugar.Errorf("doWork error: %s", err)
 	}
@@ -127,7 +278,7 @@ func (s *OpenSea) Monitor(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(s.interval):
+		case <-time.After(s.schedule.tick()):
 			if err := s.doWork(ctx); err != nil {
 				s.Sugar.Errorf("doWork error: %s", err)
 			}
@@ -138,6 +289,7 @@ func (s *OpenSea) Monitor(ctx context.Context) error {
 func (s *OpenSea) doWork(ctx context.Context) error {
 	s.Sugar.Info("doWork start")
 	defer s.Sugar.Info("doWork finish")
+	// the global last update time is only the fallback of projects without watermark
 	last, err := s.loadLastTime(ctx)
 	if err != nil {
 		//s.Sugar.Errorf("load last update time error: %s", err)
@@ -147,18 +299,16 @@ func (s *OpenSea) doWork(ctx context.Context) error {
 		s.Sugar.Infof("load last time: %s", last.String())
 	}
 
-	now := time.Now()
-	maxDelay := time.Minute * 15
-	if last == nil || last.Before(now.Add(-1*maxDelay)) {
-		oldDays := now.Add(-1 * maxDelay)
-		last = &oldDays
+	if s.chain != nil {
+		// independent of OpenSea, alerting goes on when the API is degraded
+		if err := s.requestChain(ctx); err != nil {
+			s.Sugar.Errorf("request chain error: %s", err)
+		}
 	}
-
-	s.requestOpenSea(ctx, *last, now)
-	if err = s.saveLastTime(ctx, now); err != nil {
-		s.Sugar.Errorf("save last time error: %s", err)
+	if err = s.requestOpenSea(ctx, last, time.Now()); err != nil {
+		s.Sugar.Errorf("request opensea error: %s", err)
+		return err
 	}
-	s.Sugar.Infof("save last time: %s", now.String())
 	return nil
 }
 
@@ -178,131 +328,175 @@ func (s *OpenSea) loadLastTime(ctx context.Context) (*time.Time, error) {
 	}
 }
 
-func (s *OpenSea) saveLastTime(ctx context.Context, now time.Time) error {
-	coll := s.db.Collection(collConfig)
-
-	if _, err := coll.UpdateOne(
-		ctx,
-		bson.D{
-			{"key", keyLastUpdateTime},
-		},
-		bson.D{
-			{"$set", bson.D{
-				{"value", now},
-			}},
-			{"$currentDate", bson.D{
-				{"lastModified", true},
-			}},
-		},
-		options.Update().SetUpsert(true),
-	); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (s *OpenSea) requestOpenSea(ctx context.Context, from, to time.Time) {
+// requestOpenSea fetches events of all targets (projects, tokens and wallets) from all sources
+// and saves them for the bots, every target of every source from its own watermark
+// (or `last` if no watermark of OpenSea) to `now`.
+// The watermark of one target is only advanced when its fetch succeeded,
+// returns *PollError if any target failed.
+// Targets behind more than maxDelay after their polling interval are caught up according to the catch-up policy.
+// Targets are polled by a pool of workers, the round waits for every target to report back.
+func (s *OpenSea) requestOpenSea(ctx context.Context, last *time.Time, now time.Time) error {
 	topN := make(map[string]Project)
 	if err := s.loadProjects(ctx, topN); err != nil {
 		s.Sugar.Errorf("load topN projects error: %s", err)
+		return err
 	}
-	for addr, _ := range topN {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			if err := s.requestOpenSeaProject(ctx, addr, from, to); err != nil {
-				s.Sugar.Errorf("request for single project error: %s", err)
-			}
-		}
+	chats, err := LoadChats(ctx, s.db, "")
+	if err != nil {
+		s.Sugar.Errorf("load chats error: %s", err)
+		return err
 	}
-	return
-}
-func (s *OpenSea) requestOpenSeaProject(ctx context.Context, contract string, from, to time.Time) error {
-	var events []Record
-	offset := 0
-	limit := 300
-	for {
-		result, size, err := s.requestOpenSeaProjectWithOffset(ctx, contract, from, to, offset, limit)
+	monitored := newMonitored(topN)
+	tracked := s.trackedWallets(chats)
+	if s.stream != nil {
+		s.subscribe(topN, tracked)
+	}
+	targets := append(s.targets(topN, chats), tracked.targets()...)
+	oldest := now.Add(-1 * s.catchUp.maxGap)
+	var keys []string
+	var jobs []pollJob
+	for _, source := range s.sources {
+		watermarks, err := s.loadWatermarks(ctx, source.Name())
 		if err != nil {
-			s.Sugar.Errorf("request opensea error: %s", err)
-			break
+			s.Sugar.Errorf("load watermarks of %s error: %s", source.Name(), err)
+			return err
 		}
-		if len(result) > 0 {
-			events = append(events, result...)
-			s.Sugar.Debugf("records outside size = %d", len(events))
-		}
-		if size < limit {
-			break
+		for _, t := range targets {
+			if !source.Supports(t.query) {
+				continue
+			}
+			job := pollJob{source: source, target: t, to: now, monitored: monitored, wallets: tracked}
+			keys = append(keys, job.key())
+			if !s.schedule.due(job.key(), now) {
+				continue
+			}
+			from, ok := watermarks[t.key]
+			if !ok && t.legacy != "" {
+				from, ok = watermarks[t.legacy]
+			}
+			if !ok && last != nil && source.Name() == SourceOpenSea {
+				from = *last
+			}
+			// a quiet target is polled every maxInterval, only the delay after its own interval counts
+			job.catchingUp = !from.IsZero() && now.Sub(from) > s.schedule.interval(job.key())+s.catchUp.maxDelay
+			if from.IsZero() {
+				// first poll of the target
+				from = now.Add(-1 * s.catchUp.maxDelay)
+			} else if from.Before(oldest) {
+				s.Sugar.Warnf("%s is behind since %s, events before %s are dropped", job.key(), from, oldest)
+				from = oldest
+			}
+			job.from = from
+			jobs = append(jobs, job)
 		}
-		//s.Sugar.Infof("so many events here: offset = %d, size = %d", offset, size)
-		offset += size
 	}
+	s.schedule.retain(keys)
 
-	s.Sugar.Infof("project %s events size = %d", contract, len(events))
+	s.Sugar.Infof("%d of %d targets are due", len(jobs), len(keys))
 
-	if len(events) == 0 {
-		return nil
-	}
-	chats, err := s.getAvailableChats(ctx)
-	if err != nil {
-		s.Sugar.Errorf("get available chats error: %s", err)
-		return err
+	missed := &catchUp{batch: primitive.NewObjectID().Hex()}
+	pollErr := &PollError{Total: len(jobs)}
+	for _, r := range s.poll(ctx, jobs, missed.batch) {
+		if r.done {
+			interval := s.schedule.update(r.job.key(), time.Now(), r.fetched, r.job.to.Sub(r.job.from), r.err != nil)
+			s.Sugar.Debugf("%s next poll in %s", r.job.key(), interval)
+		}
+		if r.err != nil {
+			pollErr.add(r.job.key(), r.err)
+			continue
+		}
+		if r.job.catchingUp && s.catchUp.deliver == CatchUpDigest {
+			missed.add(r.job.from, r.job.to, r.records)
+		}
 	}
-	s.Sugar.Infof("chats size = %d", len(chats))
-	for _, chat := range chats {
-		if err = s.dispatch(ctx, chat, events); err != nil {
-			s.Sugar.Errorf("dispatch events error: %s", err)
+	if len(missed.records) > 0 {
+		s.Sugar.Infof("recovered %d events missed in %s - %s", len(missed.records), missed.from, missed.to)
+		if err = s.saveDigest(ctx, missed); err != nil {
+			s.Sugar.Errorf("save digest error: %s", err)
 		}
 	}
-
-	return nil
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return pollErr.err()
 }
 
-func (s *OpenSea) requestOpenSeaProjectWithOffset(ctx context.Context, project string,
-	from, to time.Time, offset, limit int) ([]Record, int, error) {
-	url := fmt.Sprintf(
-		"https://api.opensea.io/api/v1/events?asset_contract_address=%s&only_opensea=false&occurred_after=%d&occurred_before=%d&offset=%d&limit=%d",
-		project, from.Unix(), to.Unix(), offset, limit,
-	)
-	s.Sugar.Infof("request: %s", url)
-	client := &http.Client{}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// requestTarget fetches all events of the job's target from its source in [from, to),
+// the seen events are removed, the events are tagged by the source,
+// the events of tracked wallets and collections not monitored are marked.
+// The events not in the global config are dropped after the floors, listings and rarity are stamped.
+// The number of events fetched, before any is removed, is returned too.
+func (s *OpenSea) requestTarget(ctx context.Context, job pollJob) ([]Record, int, error) {
+	events, err := job.source.Fetch(ctx, job.target.query, job.from, job.to)
 	if err != nil {
+		// drop the partial result, the whole window will be fetched again
+		s.Sugar.Errorf("request %s error: %s", job.source.Name(), err)
 		return nil, 0, err
 	}
-	resp, err := client.Do(req)
+	job.wallets.mark(events)
+	s.rates.update(events)
+	for i := range events {
+		events[i].Source = job.source.Name()
+		events[i].Extra = !job.monitored.has(events[i])
+	}
+	s.Sugar.Infof("%s events size = %d", job.key(), len(events))
+	fetched := len(events)
+	if fetched == 0 {
+		return nil, 0, nil
+	}
+	events, err = s.filterSeen(ctx, events)
 	if err != nil {
-		return nil, 0, err
+		s.Sugar.Errorf("filter seen events error: %s", err)
+		return nil, fetched, err
 	}
-	defer resp.Body.Close()
-
-	var assetEvent ResponseEvent
-	decoder := json.NewDecoder(resp.Body)
-	if err = decoder.Decode(&assetEvent); err != nil {
-		return nil, 0, err
+	if s.floors != nil {
+		s.floors.stamp(ctx, events, job.target.query.Slug)
 	}
-	if assetEvent.Success == nil {
-		s.Sugar.Debugf("request success")
-	} else if !(*assetEvent.Success) {
-		s.Sugar.Debugf("request failed")
+	if s.traits != nil {
+		// the listings are updated by all events, including the ones not wanted
+		s.traits.stamp(ctx, events, job.target.query.Slug)
 	}
-	var result []Record
-	for _, ae := range assetEvent.AssetEvents {
-		result = append(result, toRecord(ae))
+	if s.rarities != nil {
+		s.rarities.stamp(ctx, events)
 	}
-	return result, len(assetEvent.AssetEvents), nil
+	return s.eventTypes.filter(events), fetched, nil
 }
 
-func (s *OpenSea) saveEvent(ctx context.Context, records []interface{}) error {
-	if records == nil {
+// saveEvent saves records into the events collection, bots read them from there.
+// Records are from the API newest first, they are saved oldest first,
+// so bots reading in `createdAt` and `_id` order deliver them in time order.
+func (s *OpenSea) saveEvent(ctx context.Context, records []Record) error {
+	return s.insertEvents(ctx, eventDocs(records, true, time.Now()))
+}
+
+// saveChainEvent saves the records of the chain source, they are in chain order (oldest first) already.
+func (s *OpenSea) saveChainEvent(ctx context.Context, records []Record) error {
+	return s.insertEvents(ctx, eventDocs(records, false, time.Now()))
+}
+
+func (s *OpenSea) insertEvents(ctx context.Context, docs []interface{}) error {
+	if len(docs) == 0 {
 		return nil
 	}
-	coll := s.db.Collection(CollEvent)
-	_, err := coll.InsertMany(ctx, records)
+	_, err := s.db.Collection(CollEvent).InsertMany(ctx, docs)
 	return err
 }
 
+// eventDocs returns the records oldest first, created at now, reversed if they are newest first.
+func eventDocs(records []Record, newestFirst bool, now time.Time) []interface{} {
+	docs := make([]interface{}, 0, len(records))
+	for i := range records {
+		r := records[i]
+		if newestFirst {
+			r = records[len(records)-1-i]
+		}
+		r.CreatedAt = now
+		docs = append(docs, r)
+	}
+	return docs
+}
+
+// loadProjects loads the projects by their keys, with checksum addresses.
 func (s *OpenSea) loadProjects(ctx context.Context, projects map[string]Project) error {
 	coll := s.db.Collection(collProject)
 	cur, err := coll.Find(ctx, bson.D{})
@@ -317,158 +511,31 @@ func (s *OpenSea) loadProjects(ctx context.Context, projects map[string]Project)
 		return err
 	}
 	for _, p := range records {
-		p.Address = common.HexToAddress(p.Address).Hex()
-		projects[p.Address] = p
+		if p.Address != "" {
+			p.Address = common.HexToAddress(p.Address).Hex()
+		}
+		for i, a := range p.Addresses {
+			p.Addresses[i] = common.HexToAddress(a).Hex()
+		}
+		if p.Slug == "" && p.Address == "" {
+			s.Sugar.Warnf("project %s has neither slug nor address", p.Name)
+			continue
+		}
+		projects[p.key()] = p
 	}
 	return nil
 }
 
+// initIndex creates the expire index and the tail index (read by bots) of events.
 func (s *OpenSea) initIndex(ctx context.Context) error {
-	// list index first
-	coll := s.db.Collection(CollEvent)
-	indexView := coll.Indexes()
-	cursor, err := indexView.List(ctx, options.ListIndexes().SetMaxTime(time.Second*2))
-	if err != nil {
+	if err := s.ensureTTLIndex(ctx, CollEvent, expireIndexName, "createdAt", s.retention); err != nil {
 		return err
 	}
-	var indexes []bson.M
-	if err = cursor.All(ctx, &indexes); err != nil {
-		return err
-	}
-	for _, index := range indexes {
-		if index["name"] == expireIndexName {
-			//log.Println("index already exist")
-			return nil
-		}
-	}
+	coll := s.db.Collection(CollEvent)
 	index := mongo.IndexModel{
-		Keys:    bson.D{{"createdAt", 1}},
-		Options: options.Index().SetExpireAfterSeconds(60 * 10).SetName(expireIndexName), // 10 min
-	}
-	name, err := indexView.CreateOne(ctx, index)
-	if err != nil {
-		return err
-	}
-	s.Sugar.Infof("create index %s", name)
-	return nil
-}
-
-func (s *OpenSea) getAvailableChats(ctx context.Context) ([]Configuration, error) {
-	coll := s.db.Collection(CollPreferences)
-	var chats []Configuration
-	cur, err := coll.Find(ctx,
-		bson.D{
-			{"bot", s.cfg.Telegram.Bot},
-		},
-	)
-	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, nil
-		}
-		return nil, err
+		Keys:    bson.D{{"createdAt", 1}, {"_id", 1}},
+		Options: options.Index().SetName(tailIndexName),
 	}
-	if err = cur.All(ctx, &chats); err != nil {
-		return nil, err
-	}
-	return chats, nil
-}
-
-func (s *OpenSea) dispatch(ctx context.Context, chat Configuration, records []Record) error {
-	var filter bool
-	projects := make(map[string]bool)
-	for _, p := range chat.Projects {
-		projects[common.HexToAddress(p.Address).Hex()] = true
-	}
-	if len(projects) > 0 {
-		filter = true
-	}
-	//s.Sugar.Infof("filter map: %v", projects)
-	var count int
-	for i := len(records) - 1; i >= 0; i-- {
-		r := records[i]
-		// send all message even if cancelled context
-		if filter {
-			if _, ok := projects[r.Contract]; !ok {
-				s.Sugar.Debugf("event contract filtered: %s %s", r.Collection, r.Contract)
-				continue
-			}
-		}
-		content := format(r, chat.Options)
-		msg := tgbotapi.NewMessage(chat.ChatId, content)
-		if _, err := s.tg.Send(msg); err != nil {
-			s.Sugar.Errorf("send message error: %s", err)
-		}
-		count++
-		if count == 100 {
-			time.Sleep(time.Second * 10)
-			count = 0
-		}
-		//}
-	}
-
-	return nil
-}
-
-// format is for Telegram text message.
-func format(record Record, options map[string]bool) string {
-	link := options[OptionLink]
-	//properties := options[telegram.OptionProperties]
-	content := fmt.Sprintf("项目: %s\n名称: %s\nTokenId: %s", record.Collection, record.Name, record.Id)
-	switch record.Event {
-	case EventSale:
-		content += fmt.Sprintf(
-			` 成交(Sale)
-  买家: %s
-  卖家: %s
-  价格: %s`,
-			record.To, record.From, record.Price,
-		)
-	case EventOffer:
-		content += fmt.Sprintf(
-			` 出价(Offer)
-  买家: %s
-  价格: %s`,
-			record.From, record.Price,
-		)
-	case EventBid:
-		content += fmt.Sprintf(
-			` 出价(Bid)
-  买家: %s
-  价格: %s`,
-			record.From, record.Price,
-		)
-	case EventTypeBidCancel:
-		content += fmt.Sprintf(
-			` 撤销出价(Bid Cancel)
-  买家: %s
-  价格: %s`,
-			record.From, record.Price,
-		)
-	case EventTransfer:
-		content += fmt.Sprintf(
-			` 转让(Transfer)
-  发送方: %s
-  接收方: %s`,
-			record.From, record.To,
-		)
-	case EventMint:
-		content += fmt.Sprintf(
-			` 铸造完成 (Mint)
-  接收方: %s`,
-			record.To,
-		)
-	case EventList:
-		content += fmt.Sprintf(
-			` 拍卖(List)
-  卖家: %s
-  价格: %s`,
-			record.From, record.Price,
-		)
-	default:
-	}
-	content += fmt.Sprintf("\n  时间: %s", record.Date)
-	if link {
-		content += fmt.Sprintf("\n地址: https://opensea.io/assets/%s/%s\n预览图片: \n%s", strings.ToLower(record.Contract), record.Id, record.ImagePreviewUrl)
-	}
-	return content
+	_, err := coll.Indexes().CreateOne(ctx, index)
+	return err
 }
@@ -0,0 +1,124 @@
+package opensea
+
+import (
+	"context"
+	"errors"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers     = 4
+	defaultPollTimeout = time.Minute
+)
+
+// pollJob is polling one target from one source in [from, to).
+type pollJob struct {
+	source     EventSource
+	target     target
+	from, to   time.Time
+	catchingUp bool
+	monitored  monitored // projects of the round
+	wallets    wallets   // tracked wallets of the round
+}
+
+// key is the key of the job in schedule and logs.
+func (j pollJob) key() string {
+	return j.source.Name() + "/" + j.target.key
+}
+
+// pollResult is reported back to the poll round for every job, in the order of jobs.
+type pollResult struct {
+	job     pollJob
+	done    bool     // false if the job never started because the round was cancelled
+	records []Record // saved records
+	fetched int      // events fetched, before the seen and unwanted ones are removed
+	err     error
+	latency time.Duration
+}
+
+// poll runs jobs in a pool of at most s.workers goroutines and waits for all of them,
+// the results are in the same order of jobs.
+func (s *OpenSea) poll(ctx context.Context, jobs []pollJob, batch string) []pollResult {
+	results := make([]pollResult, len(jobs))
+	workers := s.workers
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = s.pollTarget(ctx, jobs[i], batch)
+			}
+		}()
+	}
+feed:
+	for i := range jobs {
+		select {
+		case <-ctx.Done():
+			break feed
+		case indexes <- i:
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	for i := range results {
+		if !results[i].done {
+			results[i] = pollResult{job: jobs[i], err: ctx.Err()}
+		}
+	}
+	return results
+}
+
+// pollTarget fetches, saves the events of one target and advances its watermark, in pollTimeout.
+func (s *OpenSea) pollTarget(ctx context.Context, job pollJob, batch string) (result pollResult) {
+	key := job.key()
+	start := time.Now()
+	result = pollResult{job: job, done: true}
+	defer func() {
+		result.latency = time.Since(start)
+		s.Sugar.Infof("%s polled in %s, %d events, error: %v",
+			key, result.latency, len(result.records), result.err)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, s.pollTimeout)
+	defer cancel()
+	events, fetched, err := s.requestTarget(ctx, job)
+	result.fetched = fetched
+	if err != nil {
+		if errors.Is(err, api.ErrRateLimited) {
+			s.Sugar.Warnf("%s is rate limited: %s", key, err)
+		} else {
+			s.Sugar.Errorf("request for %s error: %s", key, err)
+		}
+		// retry from the same watermark next time
+		result.err = err
+		return
+	}
+	if job.catchingUp {
+		s.Sugar.Infof("%s caught up %d events in %s (%s - %s)",
+			key, len(events), job.to.Sub(job.from), job.from, job.to)
+		if s.catchUp.deliver != CatchUpAll {
+			// not delivered one by one
+			for i := range events {
+				events[i].CatchUp = batch
+			}
+		}
+	}
+	if err = s.saveFresh(ctx, events, s.saveEvent); err != nil {
+		s.Sugar.Errorf("save events of %s error: %s", key, err)
+		result.err = err
+		return
+	}
+	result.records = events
+	if err = s.saveWatermark(ctx, job.target.key, job.source.Name(), job.to); err != nil {
+		s.Sugar.Errorf("save watermark of %s error: %s", key, err)
+		result.err = err
+	}
+	return
+}
@@ -1,6 +1,13 @@
 package opensea
 
-import "time"
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
 
 const (
 	CollPreferences = "preferences"
@@ -13,53 +20,186 @@ type Configuration struct {
 	Bot      string        `bson:"bot"` // bot username
 	ChatId   int64         `bson:"chatId"`
 	Projects []ProjectConf `bson:"projects"`
-	Options  Options       `bson:"options"`
+	// EventTypes are the events (Sale, List, ...) the chat wants, empty means all.
+	EventTypes []string `bson:"eventTypes"`
+	// Assets are single tokens the chat watches, every event of them is delivered.
+	Assets []AssetConf `bson:"assets"`
+	// Sources are the marketplaces (opensea, looksrare ...) and chain the chat follows, empty means all.
+	Sources []string `bson:"sources"`
+	// Wallets are tracked by the chat, their events in any collection are delivered.
+	Wallets []string `bson:"wallets"`
+	Options Options  `bson:"options"`
+	// Snipe is the percentage of the floor price, listings of the chat's projects below it are alerted
+	// whatever the event types and filter of the chat, e.g. 80. 0 means no alert.
+	Snipe float64 `bson:"snipe"`
+	// TraitSnipe is the percentage of the trait floor price like Snipe, the floor of the token's most valuable trait.
+	TraitSnipe float64 `bson:"traitSnipe"`
+	// Filter is a list of conditions, see compileFilter.
 	Filter   []interface{} `bson:"filter"`
 	ExpireAt time.Time     `bson:"expireAt"` // membership
+
+	filter predicate // compiled Filter, nil if no filter
+	// FilterErr is the error of Filter found by LoadChats, the chat should not be served until fixed.
+	FilterErr error `bson:"-"`
 }
 type Options = map[string]bool
 
+// ProjectConf is a project followed by the chat, by slug (all its contracts) or by one contract address.
 type ProjectConf struct {
 	Name    string `bson:"name"`
+	Slug    string `bson:"slug"`
 	Address string `bson:"address"`
 }
 
-// filter the record, return true if pass.
-// 1. list means `or`, map means `and`;
-// 2. top level is always list;
-func filter(record *Record, conf interface{}) bool {
-	list, ok := conf.([]interface{})
-	if ok {
-		for _, f := range list { // one pass is pass
-			if filter(record, f) {
-				return true
-			}
+type AssetConf struct {
+	Contract string `bson:"contract"`
+	TokenId  string `bson:"tokenId"`
+}
+
+// LoadChats loads the configurations of all chats served by the bot, or chats of all bots if bot is empty.
+func LoadChats(ctx context.Context, db *mongo.Database, bot string) ([]Configuration, error) {
+	coll := db.Collection(CollPreferences)
+	var chats []Configuration
+	query := bson.D{}
+	if bot != "" {
+		query = bson.D{{"bot", bot}}
+	}
+	cur, err := coll.Find(ctx, query)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
 		}
+		return nil, err
+	}
+	if err = cur.All(ctx, &chats); err != nil {
+		return nil, err
+	}
+	for i := range chats {
+		chats[i].FilterErr = chats[i].Compile()
+	}
+	return chats, nil
+}
+
+// Compile validates and compiles the filter of the chat, Wants applies it after compiled.
+func (chat *Configuration) Compile() error {
+	p, err := compileFilter(chat.Filter)
+	if err != nil {
+		return err
+	}
+	chat.filter = p
+	return nil
+}
+
+// Wants returns true if the chat follows the record and the record passes the chat's filter,
+// or the record is a below-floor listing the chat snipes.
+func (chat Configuration) Wants(r Record) bool {
+	return chat.follows(r) && (chat.filter == nil || chat.filter.eval(&r)) || chat.snipes(r)
+}
+
+// snipes returns true if the record is a listing of the chat's projects below Snipe percent of the floor price,
+// or below TraitSnipe percent of the trait floor price.
+func (chat Configuration) snipes(r Record) bool {
+	if !chat.sniping() || r.Extra || !chat.wantsSource(r.Source) || !chat.wantsProject(r.Slug, r.Contract) {
 		return false
 	}
-	m, ok := conf.(map[string]interface{})
-	if !ok {
+	if d, ok := r.discount(); below(d, ok, chat.Snipe) {
+		return true
+	}
+	d, ok := r.traitDiscount()
+	return below(d, ok, chat.TraitSnipe)
+}
+
+// sniping returns true if the chat wants below-floor or below-trait-floor alerts.
+func (chat Configuration) sniping() bool {
+	return chat.Snipe > 0 || chat.TraitSnipe > 0
+}
+
+// follows returns true if the record belongs to the chat's projects (or the chat follows all projects),
+// and the event type is wanted by the chat.
+// Only the events of the chat's sources are followed.
+// Any event of the chat's watched tokens or tracked wallets is followed,
+// other events out of the monitored collections are not.
+func (chat Configuration) follows(r Record) bool {
+	if !chat.wantsSource(r.Source) {
 		return false
 	}
-	for k, v := range m { // all pass is pass
-		switch k {
-		case "price":
-			if !filterPrice(record, v) {
-				return false
-			}
-		case "properties":
-			if !filterProperty(record, v) {
-				return false
-			}
+	if chat.wantsAsset(r.Contract, r.Id) || chat.wantsWallet(r.Wallets) {
+		return true
+	}
+	if r.Extra || !chat.wantsProject(r.Slug, r.Contract) {
+		return false
+	}
+	if len(chat.EventTypes) == 0 {
+		return true
+	}
+	for _, e := range chat.EventTypes {
+		if e == r.Event {
+			return true
 		}
 	}
-	return true
+	return false
 }
 
-func filterPrice(record *Record, conf interface{}) bool {
-	return true
+func (chat Configuration) wantsProject(slug, contract string) bool {
+	if len(chat.Projects) == 0 {
+		return true
+	}
+	for _, p := range chat.Projects {
+		if p.Slug != "" && p.Slug == slug {
+			return true
+		}
+		if p.Address != "" && common.HexToAddress(p.Address).Hex() == contract {
+			return true
+		}
+	}
+	return false
 }
 
-func filterProperty(record *Record, conf interface{}) bool {
-	return true
+// wantsCollection returns true if the chat follows the project, or any of its contracts.
+func (chat Configuration) wantsCollection(p Project) bool {
+	if chat.wantsProject(p.Slug, "") {
+		return true
+	}
+	for _, a := range p.contracts() {
+		if chat.wantsProject("", a) {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsSource returns true if the chat follows the source, the records saved before sources are from OpenSea.
+func (chat Configuration) wantsSource(source string) bool {
+	if len(chat.Sources) == 0 {
+		return true
+	}
+	if source == "" {
+		source = SourceOpenSea
+	}
+	for _, s := range chat.Sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+func (chat Configuration) wantsAsset(contract, tokenId string) bool {
+	for _, a := range chat.Assets {
+		if a.TokenId == tokenId && common.HexToAddress(a.Contract).Hex() == contract {
+			return true
+		}
+	}
+	return false
+}
+
+func (chat Configuration) wantsWallet(wallets []string) bool {
+	for _, w := range wallets {
+		for _, a := range chat.Wallets {
+			if common.HexToAddress(a).Hex() == w {
+				return true
+			}
+		}
+	}
+	return false
 }
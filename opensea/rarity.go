@@ -0,0 +1,356 @@
+package opensea
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// traitCountType is the trait type of the number of traits of a token, scored like other traits
+// in the normalized score.
+const traitCountType = "trait count"
+
+// the scores a collection can be ranked by
+const (
+	RarityNormalized  = "normalized"
+	RarityStatistical = "statistical"
+)
+
+// RarityConf is the `rarity` section, shared by the ranker and the monitor.
+type RarityConf struct {
+	// Enabled tags the records of ranked tokens with their rarity, independent of `traits`.
+	Enabled bool `json:"enabled"`
+	// Score is the score the ranker ranks by, normalized (default) or statistical.
+	Score string `json:"score"`
+}
+
+// Rarity is the rarity of a token in its collection, rank 1 is the rarest.
+type Rarity struct {
+	Score float64 `json:"score" bson:"score"` // the score ranked by, Normalized or Statistical
+	// Statistical is the sum of the trait scores, Normalized weights them and counts the number of traits too.
+	Statistical float64 `json:"statistical" bson:"statistical"`
+	Normalized  float64 `json:"normalized" bson:"normalized"`
+	Rank        int     `json:"rank" bson:"rank"`
+	Total       int     `json:"total" bson:"total"` // number of tokens ranked
+}
+
+// percentile is the rank in percent of the collection, e.g. 5 for the rarest 5%.
+func (r Rarity) percentile() decimal.Decimal {
+	if r.Total <= 0 {
+		return decimal.New(100, 0)
+	}
+	return decimal.NewFromInt(int64(r.Rank * 100)).Div(decimal.NewFromInt(int64(r.Total)))
+}
+
+// scoreRarity scores the tokens of a collection by their traits and ranks them by the score,
+// RarityNormalized or RarityStatistical, in the order of tokens.
+//
+// The score of a trait value is the reciprocal of its frequency, total / count.
+// A token without a trait type has the empty value of the type.
+// The statistical score of a token is the sum of its trait scores.
+// The normalized score counts the number of traits of a token as a type too, and normalizes every type
+// by its number of values, so the types with many values don't dominate the score:
+// the scores of a type are weighted by (average number of values of all types) / (number of values of the type).
+// Tokens of the same score have the same rank.
+func scoreRarity(tokens [][]Trait, by string) []Rarity {
+	total := len(tokens)
+	// values of every token by type, types and values are case-insensitive
+	values := make([]map[string][]string, total)
+	counts := make(map[string]map[string]int)
+	count := func(typ, value string) {
+		if counts[typ] == nil {
+			counts[typ] = make(map[string]int)
+		}
+		counts[typ][value]++
+	}
+	for i, traits := range tokens {
+		values[i] = make(map[string][]string)
+		n := 0
+		for _, t := range traits {
+			if t.Value == "" {
+				continue
+			}
+			typ, value := strings.ToLower(t.Type), strings.ToLower(t.Value)
+			values[i][typ] = append(values[i][typ], value)
+			count(typ, value)
+			n++
+		}
+		values[i][traitCountType] = []string{strconv.Itoa(n)}
+		count(traitCountType, strconv.Itoa(n))
+	}
+	// the tokens without a type
+	for typ, c := range counts {
+		for i := range values {
+			if _, ok := values[i][typ]; !ok {
+				c[""]++
+			}
+		}
+	}
+	sum := 0
+	for _, c := range counts {
+		sum += len(c)
+	}
+	average := float64(sum) / float64(len(counts))
+
+	rarities := make([]Rarity, total)
+	for i := range tokens {
+		r := Rarity{Total: total}
+		for typ, c := range counts {
+			weight := average / float64(len(c))
+			tokenValues, ok := values[i][typ]
+			if !ok {
+				tokenValues = []string{""}
+			}
+			for _, v := range tokenValues {
+				score := float64(total) / float64(c[v])
+				r.Normalized += score * weight
+				if typ != traitCountType {
+					r.Statistical += score
+				}
+			}
+		}
+		r.Score = r.Normalized
+		if by == RarityStatistical {
+			r.Score = r.Statistical
+		}
+		rarities[i] = r
+	}
+
+	order := make([]int, total)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return rarities[order[a]].Score > rarities[order[b]].Score
+	})
+	for k, i := range order {
+		rarities[i].Rank = k + 1
+		if k > 0 && equalScore(rarities[i].Score, rarities[order[k-1]].Score) {
+			rarities[i].Rank = rarities[order[k-1]].Rank
+		}
+	}
+	return rarities
+}
+
+// rarityBook tags the records of ranked tokens with the rarity saved by the ranker.
+type rarityBook struct {
+	store traitStore
+	Sugar *zap.SugaredLogger
+}
+
+func newRarityBook(store traitStore, sugar *zap.SugaredLogger) *rarityBook {
+	return &rarityBook{store: store, Sugar: sugar}
+}
+
+// stamp sets the rarity of the records of ranked tokens.
+func (b *rarityBook) stamp(ctx context.Context, records []Record) {
+	var keys []string
+	for _, r := range records {
+		if r.Id != "" {
+			keys = append(keys, assetKey(r.Contract, r.Id))
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	cached, err := b.store.loadTraits(ctx, keys)
+	if err != nil {
+		b.Sugar.Errorf("load rarity error: %s", err)
+		return
+	}
+	for i := range records {
+		r := &records[i]
+		if r.Id == "" {
+			continue
+		}
+		if token, ok := cached[assetKey(r.Contract, r.Id)]; ok && token.Rarity != nil {
+			rarity := *token.Rarity
+			r.Rarity = &rarity
+		}
+	}
+}
+
+// equalScore compares the scores ignoring the float rounding error of summing in different orders.
+func equalScore(a, b float64) bool {
+	d := a - b
+	return d < 1e-9*a && d > -1e-9*a
+}
+
+// RarityConfig is the part of the config file used by the rarity ranker, it needs no bot.
+type RarityConfig struct {
+	Mongo  hs.MongoConf
+	Log    hs.LogConf
+	API    api.Config `json:"api"`
+	Rarity RarityConf `json:"rarity"`
+}
+
+// RarityRanker downloads the traits of all tokens of the collections into the traits collection,
+// and saves the rarity of every token there. The monitor tags the records with the saved rarity.
+type RarityRanker struct {
+	cfg RarityConfig
+
+	Sugar  *zap.SugaredLogger
+	db     *mongo.Database
+	client *api.Client
+}
+
+func NewRarityRanker(cfg RarityConfig) *RarityRanker {
+	return &RarityRanker{cfg: cfg}
+}
+
+func (r *RarityRanker) Init(ctx context.Context) error {
+	l, err := hs.NewZapLogger(r.cfg.Log)
+	if err != nil {
+		return err
+	}
+	r.Sugar = l.Sugar()
+	r.Sugar.Info("logger initialized")
+	db, err := hs.ConnectMongo(ctx, r.cfg.Mongo)
+	if err != nil {
+		r.Sugar.Errorf("connect mongo error: %s", err)
+		return err
+	}
+	r.db = db
+	r.Sugar.Info("database initialized")
+	r.client, err = api.New(r.cfg.API)
+	if err != nil {
+		r.Sugar.Errorf("OpenSea API client init error: %s", err)
+		return err
+	}
+	r.Sugar.Infof("OpenSea API client initialized, endpoint: %s", r.client.BaseURL())
+	switch r.cfg.Rarity.Score {
+	case "":
+		r.cfg.Rarity.Score = RarityNormalized
+	case RarityNormalized, RarityStatistical:
+	default:
+		err = fmt.Errorf("unknown rarity score %s", r.cfg.Rarity.Score)
+		r.Sugar.Errorf("rarity config error: %s", err)
+		return err
+	}
+	r.Sugar.Info("RarityRanker initialized")
+	return nil
+}
+
+func (r *RarityRanker) Close(ctx context.Context) {
+	if err := r.db.Client().Disconnect(ctx); err != nil {
+		r.Sugar.Errorf("db close error: %s", err)
+	}
+	r.Sugar.Info("RarityRanker closed")
+}
+
+// Rank ranks the collections by slug, all projects with slug if none.
+// The traits of all tokens are downloaded first, unless cached is true.
+func (r *RarityRanker) Rank(ctx context.Context, slugs []string, cached bool) error {
+	if len(slugs) == 0 {
+		projects, err := r.db.Collection(collProject).Distinct(ctx, "slug", bson.D{{"slug", bson.D{{"$ne", ""}}}})
+		if err != nil {
+			r.Sugar.Errorf("load projects error: %s", err)
+			return err
+		}
+		for _, p := range projects {
+			if slug, ok := p.(string); ok {
+				slugs = append(slugs, slug)
+			}
+		}
+	}
+	for _, slug := range slugs {
+		if !cached {
+			if err := r.download(ctx, slug); err != nil {
+				r.Sugar.Errorf("download traits of %s error: %s", slug, err)
+				return err
+			}
+		}
+		if err := r.rank(ctx, slug); err != nil {
+			r.Sugar.Errorf("rank %s error: %s", slug, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// download saves the traits of all tokens of the collection, page by page.
+func (r *RarityRanker) download(ctx context.Context, slug string) error {
+	coll := r.db.Collection(collTraits)
+	cursor, count := "", 0
+	for {
+		page, err := r.client.Assets(ctx, slug, cursor)
+		if err != nil {
+			return err
+		}
+		var models []mongo.WriteModel
+		now := time.Now()
+		for _, a := range page.Assets {
+			ts := traits(a.Traits)
+			if len(ts) == 0 {
+				// unrevealed
+				continue
+			}
+			contract := common.HexToAddress(a.AssetContract.Address).Hex()
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.D{{"_id", assetKey(contract, a.TokenId)}}).
+				SetUpdate(bson.D{{"$set", bson.D{
+					{"slug", slug},
+					{"contract", contract},
+					{"id", a.TokenId},
+					{"traits", ts},
+					{"updatedAt", now},
+				}}}).
+				SetUpsert(true))
+		}
+		if len(models) > 0 {
+			if _, err = coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+				return err
+			}
+		}
+		count += len(page.Assets)
+		if cursor = page.NextCursor(); cursor == "" || len(page.Assets) == 0 {
+			break
+		}
+	}
+	r.Sugar.Infof("%d tokens of %s downloaded", count, slug)
+	return nil
+}
+
+// rank scores the cached tokens of the collection and saves their rarity.
+func (r *RarityRanker) rank(ctx context.Context, slug string) error {
+	coll := r.db.Collection(collTraits)
+	cur, err := coll.Find(ctx, bson.D{{"slug", slug}}, options.Find().SetProjection(bson.D{{"traits", 1}}))
+	if err != nil {
+		return err
+	}
+	var docs []cachedTraits
+	if err = cur.All(ctx, &docs); err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		r.Sugar.Warnf("no traits of %s cached", slug)
+		return nil
+	}
+	tokens := make([][]Trait, 0, len(docs))
+	for _, d := range docs {
+		tokens = append(tokens, d.Traits)
+	}
+	rarities := scoreRarity(tokens, r.cfg.Rarity.Score)
+	models := make([]mongo.WriteModel, 0, len(docs))
+	for i, d := range docs {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{"_id", d.Key}}).
+			SetUpdate(bson.D{{"$set", bson.D{{"rarity", rarities[i]}}}}))
+	}
+	if _, err = coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+	r.Sugar.Infof("%d tokens of %s ranked by %s score", len(docs), slug, r.cfg.Rarity.Score)
+	return nil
+}
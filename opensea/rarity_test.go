@@ -0,0 +1,97 @@
+package opensea
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestScoreRarity(t *testing.T) {
+	gold := Trait{Type: "Background", Value: "Gold"}
+	blue := Trait{Type: "Background", Value: "Blue"}
+	red := Trait{Type: "Eyes", Value: "Red"}
+	hat := Trait{Type: "Hat", Value: "Crown"}
+	id := func(v string) Trait { return Trait{Type: "Id", Value: v} }
+	// ids of many values dominate the statistical score, not the normalized one
+	ids := [][]Trait{{id("1"), blue}, {id("2"), blue}, {id("3"), blue}, {id("4"), blue, hat}, {id("4"), gold, hat}}
+
+	tests := []struct {
+		tokens [][]Trait
+		by     string
+		want   []int
+	}{
+		// the only gold is the rarest, the others are the same
+		{[][]Trait{{blue, red}, {gold, red}, {blue, red}, {blue, red}}, RarityNormalized, []int{2, 1, 2, 2}},
+		// a missing trait is a value too, and so is the trait count
+		{[][]Trait{{blue, red}, {blue, red, hat}, {blue, red}, {blue, red}}, RarityNormalized, []int{2, 1, 2, 2}},
+		// case-insensitive
+		{[][]Trait{{blue, {Type: "eyes", Value: "RED"}}, {blue, red}, {gold, red}, {blue, red}}, RarityNormalized, []int{2, 2, 1, 2}},
+		{nil, RarityNormalized, []int{}},
+		{ids, RarityNormalized, []int{3, 3, 3, 2, 1}},
+		{ids, RarityStatistical, []int{2, 2, 2, 5, 1}},
+	}
+	for i, tt := range tests {
+		rarities := scoreRarity(tt.tokens, tt.by)
+		got := make([]int, 0, len(rarities))
+		for _, r := range rarities {
+			if r.Total != len(tt.tokens) {
+				t.Errorf("%d: total = %d", i, r.Total)
+			}
+			got = append(got, r.Rank)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%d: ranks = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestRarityScores(t *testing.T) {
+	gold := Trait{Type: "Background", Value: "Gold"}
+	blue := Trait{Type: "Background", Value: "Blue"}
+	red := Trait{Type: "Eyes", Value: "Red"}
+	tokens := [][]Trait{{blue, red}, {gold, red}, {blue, red}, {blue, red}}
+	// statistical: gold 4/1 + red 4/4 = 5
+	// normalized, 4/3 values per type on average: gold 4 * (4/3)/2 + red 1 * 4/3 + trait count 1 * 4/3 = 16/3
+	r := scoreRarity(tokens, RarityStatistical)[1]
+	if !equalScore(r.Statistical, 5) || !equalScore(r.Normalized, 16.0/3) || r.Score != r.Statistical {
+		t.Errorf("statistical rarity = %+v", r)
+	}
+	if r = scoreRarity(tokens, RarityNormalized)[1]; r.Score != r.Normalized {
+		t.Errorf("normalized rarity = %+v", r)
+	}
+}
+
+func TestRarityBook(t *testing.T) {
+	const contract = "0x1A92f7381B9F03921564a437210bB9396471050C"
+	store := newMemTraitStore()
+	store.traits[assetKey(contract, "1")] = []Trait{{Type: "Background", Value: "Gold"}}
+	store.traits[assetKey(contract, "2")] = []Trait{{Type: "Background", Value: "Blue"}}
+	store.rarities[assetKey(contract, "1")] = &Rarity{Score: 2.5, Rank: 1, Total: 100}
+	book := newRarityBook(store, zap.NewNop().Sugar())
+	records := []Record{{Contract: contract, Id: "1"}, {Contract: contract, Id: "2"}, {Contract: contract, Id: "3"}}
+	book.stamp(context.Background(), records)
+	if records[0].Rarity == nil || records[0].Rarity.Rank != 1 || records[1].Rarity != nil || records[2].Rarity != nil {
+		t.Errorf("rarity = %+v, %+v, %+v", records[0].Rarity, records[1].Rarity, records[2].Rarity)
+	}
+	// the traits are not filled without the trait book
+	if len(records[0].Traits) != 0 {
+		t.Errorf("traits = %v", records[0].Traits)
+	}
+}
+
+func TestRarityPercentile(t *testing.T) {
+	tests := []struct {
+		rarity Rarity
+		want   string
+	}{
+		{Rarity{Rank: 123, Total: 10000}, "1.23"},
+		{Rarity{Rank: 1, Total: 4}, "25"},
+		{Rarity{Rank: 1}, "100"},
+	}
+	for i, tt := range tests {
+		if got := tt.rarity.percentile().String(); got != tt.want {
+			t.Errorf("%d: percentile = %s, want %s", i, got, tt.want)
+		}
+	}
+}
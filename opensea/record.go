@@ -1,21 +1,58 @@
 package opensea
 
-import "time"
+import (
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"time"
+)
 
 type Record struct {
-	Collection string `json:"collection"` // collection name
-	Contract   string `json:"contract"`   // collection contract address
-	Name       string `json:"name"`       // NFT name
-	Id         string `json:"id"`
-	Event      string `json:"event"`
-	Price      string `json:"price"`
-	From       string `json:"from"`
-	To         string `json:"to"`
-	Date       string `json:"date"`
+	Source     string `json:"source" bson:"source"`         // event source, e.g. opensea, chain
+	EventId    string `json:"eventId" bson:"eventId"`       // event id of the source
+	TxHash     string `json:"txHash" bson:"txHash"`         // empty for off-chain events
+	Collection string `json:"collection" bson:"collection"` // collection name
+	Slug       string `json:"slug" bson:"slug"`             // collection slug on OpenSea
+	Contract   string `json:"contract" bson:"contract"`     // collection contract address
+	Name       string `json:"name" bson:"name"`             // NFT name
+	Id         string `json:"id" bson:"id"`
+	Event      string `json:"event" bson:"event"`
+	// Price is the amount of Payment paid or asked, nil for events without price (Mint and Transfer).
+	Price   *Decimal `json:"price,omitempty" bson:"price,omitempty"`
+	Payment Token    `json:"payment" bson:"payment"`
+	// ETH and USD are the values of Price in ETH and US dollars, nil if the rates are unknown,
+	// so prices of different tokens can be compared.
+	ETH      *Decimal `json:"eth,omitempty" bson:"eth,omitempty"`
+	USD      *Decimal `json:"usd,omitempty" bson:"usd,omitempty"`
+	Quantity int64    `json:"quantity" bson:"quantity"` // number of tokens, more than 1 for ERC-1155
+	// Bundle is the number of NFTs sold together in one order, the Price is the share of this one.
+	Bundle int `json:"bundle,omitempty" bson:"bundle,omitempty"`
+	// Floor is the floor price of the collection in ETH when listed, only for listings valued in ETH.
+	Floor *Decimal `json:"floor,omitempty" bson:"floor,omitempty"`
+	// Rarity is the rarity of the token in its collection, if ranked.
+	Rarity *Rarity `json:"rarity,omitempty" bson:"rarity,omitempty"`
+	// TraitFloor is the highest floor price of the token's traits when listed, only for listings valued in ETH.
+	TraitFloor *TraitFloor `json:"traitFloor,omitempty" bson:"traitFloor,omitempty"`
+	// FromAddress and ToAddress are the full addresses, FromName and ToName are the user names on OpenSea if any.
+	FromAddress string    `json:"fromAddress" bson:"fromAddress"`
+	ToAddress   string    `json:"toAddress" bson:"toAddress"`
+	FromName    string    `json:"fromName,omitempty" bson:"fromName,omitempty"`
+	ToName      string    `json:"toName,omitempty" bson:"toName,omitempty"`
+	Time        time.Time `json:"time" bson:"time"` // when the event happened
+
+	ImagePreviewUrl string `json:"imagePreviewUrl" bson:"imagePreviewUrl"` // for Telegram preview
+	// Traits are the properties of the token, if known.
+	Traits []Trait `json:"traits,omitempty" bson:"traits,omitempty"`
 
-	ImagePreviewUrl string `json:"imagePreviewUrl"` // for Telegram preview
+	// Wallets are the tracked wallets taking part in the event.
+	Wallets []string `json:"wallets,omitempty" bson:"wallets,omitempty"`
+	// Extra is true if the collection is not monitored, the event is polled for a watched token or a tracked wallet.
+	Extra bool `json:"extra,omitempty" bson:"extra,omitempty"`
 
-	CreatedAt time.Time `json:"createdAt"`
+	// CatchUp is the catch-up batch id of events missed during downtime,
+	// they are not delivered one by one but in the digest of the batch.
+	CatchUp string        `json:"catchUp,omitempty" bson:"catchUp,omitempty"`
+	Digest  *DigestWindow `json:"digest,omitempty" bson:"digest,omitempty"` // only for EventDigest
+
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
 }
 
 const (
@@ -23,23 +60,71 @@ const (
 	EventOffer     = "Offer"
 	EventBid       = "Bid"
 	EventBidCancel = "Bid Cancel"
-	EventTransfer  = "Transfer"
-	EventMint      = "Mint"
-	EventList      = "List"
+	// EventListCancel is a listing cancelled by the seller.
+	EventListCancel = "List Cancel"
+	EventTransfer   = "Transfer"
+	EventMint       = "Mint"
+	EventList       = "List"
+
+	// EventDigest is the marker of a catch-up batch, not a real event.
+	EventDigest = "Digest"
 )
 
+// Trait is a property of the token, e.g. Background: Gold.
+type Trait struct {
+	Type  string `json:"type" bson:"type"`
+	Value string `json:"value" bson:"value"`
+}
+
+// TraitFloor is the lowest price in ETH of the active listings with the trait.
+type TraitFloor struct {
+	Type  string   `json:"type" bson:"type"`
+	Value string   `json:"value" bson:"value"`
+	Price *Decimal `json:"price" bson:"price"`
+}
+
+// DigestWindow is the missed period of a catch-up batch.
+type DigestWindow struct {
+	From time.Time `json:"from" bson:"from"`
+	To   time.Time `json:"to" bson:"to"`
+}
+
 // Item is collection for project.
-// One Item is one collection on OpenSea.
+// One Item is one collection on OpenSea, with all its contracts.
 type Item struct {
-	Name    string  `bson:"name"`
-	Address string  `bson:"address"`
-	Stats   RawStat `bson:"stats"`
+	Name      string      `bson:"name"`
+	Slug      string      `bson:"slug"`
+	Address   string      `bson:"address"`   // the first contract
+	Addresses []string    `bson:"addresses"` // all primary asset contracts
+	Stats     api.RawStat `bson:"stats"`
 	//Traits *Traits
 }
 
-// Project is a collection saved in MongoDB
+// Project is a collection saved in MongoDB, identified by its OpenSea slug.
+// A project without slug (saved before slugs) is identified by its only Address.
 type Project struct {
-	Index   int    `bson:"index"`
-	Name    string `bson:"name"`
-	Address string `bson:"address"`
+	Index     int      `bson:"index"`
+	Name      string   `bson:"name"`
+	Slug      string   `bson:"slug"`
+	Address   string   `bson:"address"`   // the first contract
+	Addresses []string `bson:"addresses"` // all contracts
+}
+
+// key is the project's target key, for watermark and schedule.
+func (p Project) key() string {
+	if p.Slug != "" {
+		return collectionKey(p.Slug)
+	}
+	return p.Address
+}
+
+// contracts returns all contracts of the project.
+func (p Project) contracts() []string {
+	if len(p.Addresses) > 0 {
+		return p.Addresses
+	}
+	if p.Address != "" {
+		return []string{p.Address}
+	}
+	return nil
 }
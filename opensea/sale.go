@@ -0,0 +1,256 @@
+package opensea
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/convert"
+	"math/big"
+	"strings"
+)
+
+var (
+	// SeaportAddress is Seaport 1.1, WyvernAddress is Wyvern Exchange 2.3 of OpenSea.
+	SeaportAddress = common.HexToAddress("0x00000000006c3852cbEf3e08E8dF289169EdE581")
+	WyvernAddress  = common.HexToAddress("0x7f268357A8c2552623316e2562D90e642bB538E5")
+)
+
+// marketABI is the settlement events of the marketplaces.
+const marketABI = `[
+{"type":"event","name":"OrderFulfilled","anonymous":false,"inputs":[
+	{"name":"orderHash","type":"bytes32","indexed":false},
+	{"name":"offerer","type":"address","indexed":true},
+	{"name":"zone","type":"address","indexed":true},
+	{"name":"recipient","type":"address","indexed":false},
+	{"name":"offer","type":"tuple[]","indexed":false,"components":[
+		{"name":"itemType","type":"uint8"},
+		{"name":"token","type":"address"},
+		{"name":"identifier","type":"uint256"},
+		{"name":"amount","type":"uint256"}]},
+	{"name":"consideration","type":"tuple[]","indexed":false,"components":[
+		{"name":"itemType","type":"uint8"},
+		{"name":"token","type":"address"},
+		{"name":"identifier","type":"uint256"},
+		{"name":"amount","type":"uint256"},
+		{"name":"recipient","type":"address"}]}]},
+{"type":"event","name":"OrdersMatched","anonymous":false,"inputs":[
+	{"name":"buyHash","type":"bytes32","indexed":false},
+	{"name":"sellHash","type":"bytes32","indexed":false},
+	{"name":"maker","type":"address","indexed":true},
+	{"name":"taker","type":"address","indexed":true},
+	{"name":"price","type":"uint256","indexed":false},
+	{"name":"metadata","type":"bytes32","indexed":true}]}
+]`
+
+var (
+	marketEvents = func() abi.ABI {
+		parsed, err := abi.JSON(strings.NewReader(marketABI))
+		if err != nil {
+			panic(err)
+		}
+		return parsed
+	}()
+	topicOrderFulfilled = marketEvents.Events["OrderFulfilled"].ID
+	topicOrdersMatched  = marketEvents.Events["OrdersMatched"].ID
+)
+
+// Seaport item types.
+const (
+	itemNative = iota
+	itemERC20
+	itemERC721
+	itemERC1155
+	itemERC721WithCriteria
+	itemERC1155WithCriteria
+)
+
+type spentItem struct {
+	ItemType   uint8
+	Token      common.Address
+	Identifier *big.Int
+	Amount     *big.Int
+}
+
+type receivedItem struct {
+	ItemType   uint8
+	Token      common.Address
+	Identifier *big.Int
+	Amount     *big.Int
+	Recipient  common.Address
+}
+
+type orderFulfilled struct {
+	OrderHash     [32]byte
+	Recipient     common.Address
+	Offer         []spentItem
+	Consideration []receivedItem
+}
+
+// paymentTokens are the known ERC-20 tokens paid on the marketplaces,
+// other tokens are shown in the smallest unit with the token address.
+var paymentTokens = map[common.Address]Token{
+	{}: {Symbol: "ETH", Decimals: 18},
+	common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"): {Symbol: "WETH", Decimals: 18},
+	common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"): {Symbol: "USDC", Decimals: 6},
+	common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F"): {Symbol: "DAI", Decimals: 18},
+}
+
+func paymentToken(address common.Address) Token {
+	t, ok := paymentTokens[address]
+	if !ok {
+		t = Token{Symbol: convert.ShortAddress(address.Hex())}
+	}
+	t.Address = address.Hex()
+	return t
+}
+
+// decodeOrderFulfilled converts a Seaport `OrderFulfilled` log to sales, one for each NFT.
+// A listing (NFT offered) is sold by the offerer to the recipient,
+// an offer (token offered) is accepted by the recipient, who sells the NFT to the offerer.
+// The price is what the buyer paid, fees included.
+func decodeOrderFulfilled(l types.Log) ([]Record, error) {
+	if len(l.Topics) != 3 {
+		return nil, fmt.Errorf("bad OrderFulfilled log")
+	}
+	var e orderFulfilled
+	if err := marketEvents.UnpackIntoInterface(&e, "OrderFulfilled", l.Data); err != nil {
+		return nil, err
+	}
+	offerer := common.BytesToAddress(l.Topics[1].Bytes())
+	type nft struct {
+		token  common.Address
+		id     *big.Int
+		amount *big.Int
+	}
+	var nfts []nft
+	var payments []spentItem
+	seller, buyer := offerer, e.Recipient
+	for _, item := range e.Offer {
+		if isNFT(item.ItemType) {
+			nfts = append(nfts, nft{item.Token, item.Identifier, item.Amount})
+		} else {
+			payments = append(payments, item)
+		}
+	}
+	if len(nfts) > 0 {
+		// listing, the consideration is paid by the buyer
+		payments = payments[:0]
+		for _, item := range e.Consideration {
+			if !isNFT(item.ItemType) {
+				payments = append(payments, spentItem{item.ItemType, item.Token, item.Identifier, item.Amount})
+			}
+		}
+	} else {
+		// offer accepted, the offered token is paid by the buyer
+		seller, buyer = e.Recipient, offerer
+		for _, item := range e.Consideration {
+			if isNFT(item.ItemType) {
+				nfts = append(nfts, nft{item.Token, item.Identifier, item.Amount})
+			}
+		}
+	}
+	if len(nfts) == 0 || len(payments) == 0 {
+		// NFT swap or a payment without NFT, not a sale
+		return nil, nil
+	}
+	total := new(big.Int)
+	for _, p := range payments {
+		if p.Token != payments[0].Token {
+			return nil, fmt.Errorf("order %x is paid in several tokens", e.OrderHash)
+		}
+		total.Add(total, p.Amount)
+	}
+	records := make([]Record, 0, len(nfts))
+	for _, n := range nfts {
+		r := saleRecord(l, n.token, seller, buyer)
+		r.Id = n.id.String()
+		r.Quantity = n.amount.Int64()
+		records = append(records, r)
+	}
+	splitPrice(records, total, paymentToken(payments[0].Token))
+	return records, nil
+}
+
+// splitPrice sets the price of the NFTs sold in one order, the total is split evenly in a bundle.
+func splitPrice(records []Record, total *big.Int, token Token) {
+	share := decimal.NewFromBigInt(total, 0)
+	if len(records) > 1 {
+		share = share.Div(decimal.NewFromInt(int64(len(records))))
+	}
+	for i := range records {
+		records[i].setPrice(share.String(), token)
+		if len(records) > 1 {
+			records[i].Bundle = len(records)
+		}
+	}
+}
+
+// decodeOrdersMatched converts a Wyvern `OrdersMatched` log to sales, by the logs before it in the receipt.
+// The NFTs are the transfers after the previous `OrdersMatched` (more than one in a bulk buy),
+// the payment token is the ERC-20 transferred among them, or ETH if none.
+func decodeOrdersMatched(l types.Log, receipt *types.Receipt) ([]Record, error) {
+	if len(l.Topics) != 4 {
+		return nil, fmt.Errorf("bad OrdersMatched log")
+	}
+	values, err := marketEvents.Unpack("OrdersMatched", l.Data)
+	if err != nil {
+		return nil, err
+	}
+	price, ok := values[2].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("bad OrdersMatched price")
+	}
+	var segment []*types.Log
+	for _, rl := range receipt.Logs {
+		if rl.Index >= l.Index {
+			break
+		}
+		if len(rl.Topics) > 0 && rl.Topics[0] == topicOrdersMatched && rl.Address == l.Address {
+			segment = segment[:0]
+			continue
+		}
+		segment = append(segment, rl)
+	}
+	token := common.Address{}
+	var records []Record
+	for _, rl := range segment {
+		if len(rl.Topics) == 3 && rl.Topics[0] == topicTransfer {
+			// ERC-20 payment
+			token = rl.Address
+			continue
+		}
+		transfers, err := decodeTransfer(*rl)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range transfers {
+			r := saleRecord(l, common.HexToAddress(t.Contract),
+				common.HexToAddress(t.FromAddress), common.HexToAddress(t.ToAddress))
+			r.Id = t.Id
+			r.Quantity = t.Quantity
+			records = append(records, r)
+		}
+	}
+	splitPrice(records, price, paymentToken(token))
+	return records, nil
+}
+
+func isNFT(itemType uint8) bool {
+	switch itemType {
+	case itemERC721, itemERC1155, itemERC721WithCriteria, itemERC1155WithCriteria:
+		return true
+	}
+	return false
+}
+
+func saleRecord(l types.Log, contract, seller, buyer common.Address) Record {
+	return Record{
+		TxHash:      l.TxHash.Hex(),
+		Contract:    contract.Hex(),
+		Event:       EventSale,
+		FromAddress: seller.Hex(),
+		ToAddress:   buyer.Hex(),
+	}
+}
@@ -0,0 +1,141 @@
+package opensea
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultHotEvents = 20
+
+// ScheduleConf makes the polling interval of every project adaptive.
+// A project expected to have at least HotEvents events in its interval, at the event rate of its last window,
+// is polled twice as often, a project without any event is polled half as often,
+// always within [MinInterval, MaxInterval].
+// The monitor wakes up every MinInterval to poll the due projects.
+// Without this config every project is polled every Interval.
+type ScheduleConf struct {
+	MinInterval string `json:"minInterval"` // default Interval
+	MaxInterval string `json:"maxInterval"` // default Interval
+	HotEvents   int    `json:"hotEvents"`   // default 20
+}
+
+type scheduler struct {
+	base time.Duration // first interval of a project
+	min  time.Duration
+	max  time.Duration
+	hot  int
+
+	mu       sync.Mutex
+	projects map[string]*projectSchedule
+}
+
+type projectSchedule struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newScheduler(base time.Duration, conf ScheduleConf) (*scheduler, error) {
+	s := &scheduler{
+		base:     base,
+		min:      base,
+		max:      base,
+		hot:      conf.HotEvents,
+		projects: make(map[string]*projectSchedule),
+	}
+	var err error
+	if conf.MinInterval != "" {
+		if s.min, err = time.ParseDuration(conf.MinInterval); err != nil {
+			return nil, fmt.Errorf("schedule minInterval %s format error: %w", conf.MinInterval, err)
+		}
+	}
+	if conf.MaxInterval != "" {
+		if s.max, err = time.ParseDuration(conf.MaxInterval); err != nil {
+			return nil, fmt.Errorf("schedule maxInterval %s format error: %w", conf.MaxInterval, err)
+		}
+	}
+	if s.min <= 0 || s.max < s.min {
+		return nil, fmt.Errorf("schedule interval range [%s, %s] is invalid", s.min, s.max)
+	}
+	if s.base < s.min {
+		s.base = s.min
+	} else if s.base > s.max {
+		s.base = s.max
+	}
+	if s.hot <= 0 {
+		s.hot = defaultHotEvents
+	}
+	return s, nil
+}
+
+// tick is how often the monitor checks the due projects.
+func (s *scheduler) tick() time.Duration {
+	return s.min
+}
+
+// due returns true if the project should be polled at now, a new project is always due.
+func (s *scheduler) due(project string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.projects[project]
+	return !ok || !now.Before(p.next)
+}
+
+// interval returns the current interval of the project, the first interval if new.
+func (s *scheduler) interval(project string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.projects[project]; ok {
+		return p.interval
+	}
+	return s.base
+}
+
+// update adjusts the interval of the project by the events of its last window and schedules the next poll.
+// events are all the events fetched in the window, seen or not, and they are normalized by the window,
+// so a long window (e.g. catching up) or events polled again don't look like more activity.
+// The interval is kept once fewer than hot events are expected in it, a steady activity keeps a steady interval.
+// A failed poll keeps the interval.
+func (s *scheduler) update(project string, now time.Time, events int, window time.Duration, failed bool) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.projects[project]
+	if !ok {
+		p = &projectSchedule{interval: s.base}
+		s.projects[project] = p
+	}
+	if !failed {
+		if window <= 0 {
+			window = p.interval
+		}
+		expected := float64(events) * float64(p.interval) / float64(window)
+		switch {
+		case expected >= float64(s.hot):
+			p.interval /= 2
+		case events == 0:
+			p.interval *= 2
+		}
+		if p.interval < s.min {
+			p.interval = s.min
+		} else if p.interval > s.max {
+			p.interval = s.max
+		}
+	}
+	p.next = now.Add(p.interval)
+	return p.interval
+}
+
+// retain forgets the targets not in the list, e.g. removed from the projects collection.
+func (s *scheduler) retain(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	retained := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		retained[k] = true
+	}
+	for k := range s.projects {
+		if !retained[k] {
+			delete(s.projects, k)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package opensea
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	s, err := newScheduler(time.Minute, ScheduleConf{MinInterval: "15s", MaxInterval: "4m", HotEvents: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if !s.due("a", now) {
+		t.Fatal("new project should be due")
+	}
+	tests := []struct {
+		events int
+		window time.Duration
+		failed bool
+		want   time.Duration
+	}{
+		{10, time.Minute, false, 30 * time.Second},
+		{50, 30 * time.Second, false, 15 * time.Second},
+		{50, 15 * time.Second, false, 15 * time.Second}, // min
+		{3, 15 * time.Second, false, 15 * time.Second},
+		{0, 15 * time.Second, true, 15 * time.Second},
+		{0, 15 * time.Second, false, 30 * time.Second},
+		{0, 30 * time.Second, false, time.Minute},
+		{0, time.Minute, false, 2 * time.Minute},
+		{0, 2 * time.Minute, false, 4 * time.Minute},
+		{0, 4 * time.Minute, false, 4 * time.Minute}, // max
+		// caught up an hour, 4 events expected in the interval
+		{60, time.Hour, false, 4 * time.Minute},
+	}
+	for i, tt := range tests {
+		if got := s.update("a", now, tt.events, tt.window, tt.failed); got != tt.want {
+			t.Errorf("%d: interval = %s, want %s", i, got, tt.want)
+		}
+	}
+	if s.interval("a") != 4*time.Minute || s.interval("b") != time.Minute {
+		t.Errorf("intervals = %s, %s", s.interval("a"), s.interval("b"))
+	}
+	if s.due("a", now.Add(time.Minute)) || !s.due("a", now.Add(4*time.Minute)) {
+		t.Error("project should be due after its interval")
+	}
+	s.retain(nil)
+	if !s.due("a", now) {
+		t.Error("forgotten project should be due")
+	}
+}
+
+func TestSchedulerSteady(t *testing.T) {
+	s, err := newScheduler(time.Minute, ScheduleConf{MinInterval: "15s", MaxInterval: "4m", HotEvents: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an event every 5s: 12 in a minute is hot, 6 in 30s is not
+	const every = 5 * time.Second
+	now := time.Now()
+	window := s.interval("a")
+	var intervals []time.Duration
+	for i := 0; i < 8; i++ {
+		interval := s.update("a", now, int(window/every), window, false)
+		intervals = append(intervals, interval)
+		// the window of the next poll, a little later than due
+		window = interval + time.Second
+		now = now.Add(window)
+	}
+	for i, interval := range intervals[1:] {
+		if interval != 30*time.Second {
+			t.Errorf("intervals = %v, %d not stable", intervals, i+1)
+			break
+		}
+	}
+}
+
+func TestSchedulerDefault(t *testing.T) {
+	s, err := newScheduler(10*time.Second, ScheduleConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.tick() != 10*time.Second || s.update("a", time.Now(), 100, 10*time.Second, false) != 10*time.Second {
+		t.Error("default schedule should be the fixed interval")
+	}
+	if _, err = newScheduler(time.Minute, ScheduleConf{MinInterval: "2m", MaxInterval: "1m"}); err == nil {
+		t.Error("min > max should be error")
+	}
+}
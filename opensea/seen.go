@@ -0,0 +1,290 @@
+package opensea
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	collSeen = "seen"
+
+	seenExpireIndexName = "seenExpireIndex"
+
+	defaultSeenTTL = time.Hour * 72
+
+	errCodeDuplicateKey = 11000
+
+	unmarkSeenTimeout = time.Second * 10
+)
+
+// seenKeys are the de-duplication keys of the record, a record is seen if any of its keys is seen.
+// Empty if the record can't be identified.
+//
+// On-chain events (Mint, Transfer and Sale) are keyed by transaction,
+// so the same event from OpenSea and from chain is delivered once.
+// Other events are keyed by the event id of the source. Orders (List, Offer, Bid and List Cancel) of OpenSea
+// are also keyed by token, price and order time, the streamed ones have no event id of the API;
+// an order placed again at the same price is a new one.
+func seenKeys(r Record) []string {
+	txKey := ""
+	if r.TxHash != "" {
+		txKey = strings.ToLower(r.TxHash) + ":" + r.Event + ":" + r.Contract + ":" + r.Id
+	}
+	if txKey != "" && (r.Event == EventMint || r.Event == EventTransfer || r.Event == EventSale) {
+		return []string{txKey}
+	}
+	var keys []string
+	if r.EventId != "" {
+		if r.Source != "" && r.Source != SourceOpenSea {
+			// event ids of different sources may be the same
+			keys = append(keys, r.Source+":"+r.EventId)
+		} else {
+			keys = append(keys, r.EventId)
+		}
+	}
+	if key := orderKey(r); key != "" {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 && txKey != "" {
+		keys = append(keys, txKey)
+	}
+	return keys
+}
+
+// orderKey is the key of an OpenSea order by maker, token, price and the time it was placed (or cancelled),
+// empty for other records.
+func orderKey(r Record) string {
+	if r.Source != "" && r.Source != SourceOpenSea || r.Time.IsZero() {
+		return ""
+	}
+	switch r.Event {
+	case EventList, EventOffer, EventBid, EventListCancel:
+	default:
+		return ""
+	}
+	return strings.ToLower(r.FromAddress) + ":" + r.Event + ":" + r.Contract + ":" + r.Id + ":" + priceText(r) +
+		":" + strconv.FormatInt(r.Time.Unix(), 10)
+}
+
+// seenDoc is a de-duplication key in the seen collection.
+type seenDoc struct {
+	Key       string    `bson:"_id"`
+	CreatedAt time.Time `bson:"createdAt"`
+	Price     string    `bson:"price,omitempty"` // of a sale, for cross-check
+}
+
+// seenStore keeps the de-duplication keys of the saved records.
+type seenStore interface {
+	// insert inserts the docs, returns the indexes of the docs whose key is already there.
+	insert(ctx context.Context, docs []seenDoc) (map[int]bool, error)
+	delete(ctx context.Context, keys []string) error
+	// prices returns the prices of the seen sales by key.
+	prices(ctx context.Context, keys []string) (map[string]string, error)
+}
+
+// eventSaver saves the records, see saveEvent.
+type eventSaver func(ctx context.Context, records []Record) error
+
+// filterSeen marks records as seen and returns the records never seen before, in the original order.
+// The seen-set survives restart and expires after the configured TTL.
+// The price of a sale is kept, the duplicated sale from another source is cross-checked with it.
+// The returned records must be saved by saveFresh, or they are never delivered.
+func (s *OpenSea) filterSeen(ctx context.Context, records []Record) ([]Record, error) {
+	var docs []seenDoc
+	var indexes []int // index in records of docs[i]
+	now := time.Now()
+	for i, r := range records {
+		for _, key := range seenKeys(r) {
+			doc := seenDoc{Key: key, CreatedAt: now}
+			if r.Event == EventSale {
+				doc.Price = priceText(r)
+			}
+			docs = append(docs, doc)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(docs) == 0 {
+		return records, nil
+	}
+	seen, err := s.seen.insert(ctx, docs)
+	if err != nil {
+		return nil, err
+	}
+	duplicated := make(map[int]bool, len(seen))
+	for i := range seen {
+		duplicated[indexes[i]] = true
+	}
+	if len(duplicated) == 0 {
+		return records, nil
+	}
+	fresh := make([]Record, 0, len(records)-len(duplicated))
+	var sales []Record
+	for i, r := range records {
+		if duplicated[i] {
+			s.Sugar.Debugf("duplicated event suppressed: %s %s %s", r.EventId, r.Event, r.Collection)
+			if r.Event == EventSale {
+				sales = append(sales, r)
+			}
+			continue
+		}
+		fresh = append(fresh, r)
+	}
+	s.Sugar.Infof("%d duplicated events suppressed", len(duplicated))
+	if len(sales) > 0 {
+		if err = s.crossCheck(ctx, sales); err != nil {
+			s.Sugar.Errorf("cross-check sales error: %s", err)
+		}
+	}
+	return fresh, nil
+}
+
+// saveFresh saves the records returned by filterSeen. If failed, they are not seen any more,
+// so they are delivered when fetched again, e.g. by the next poll from the same watermark.
+func (s *OpenSea) saveFresh(ctx context.Context, records []Record, save eventSaver) error {
+	err := save(ctx, records)
+	if err == nil {
+		return nil
+	}
+	var keys []string
+	for _, r := range records {
+		keys = append(keys, seenKeys(r)...)
+	}
+	if len(keys) > 0 {
+		// the context may be done, e.g. by the poll timeout
+		ctx, cancel := context.WithTimeout(context.Background(), unmarkSeenTimeout)
+		defer cancel()
+		if e := s.seen.delete(ctx, keys); e != nil {
+			s.Sugar.Errorf("unmark %d seen events error: %s", len(keys), e)
+		}
+	}
+	return err
+}
+
+// crossCheck compares the price of the duplicated sales with the first seen ones, warns if differ.
+func (s *OpenSea) crossCheck(ctx context.Context, sales []Record) error {
+	keys := make([]string, 0, len(sales))
+	for _, r := range sales {
+		keys = append(keys, seenKeys(r)...)
+	}
+	prices, err := s.seen.prices(ctx, keys)
+	if err != nil {
+		return err
+	}
+	for _, r := range sales {
+		key := seenKeys(r)[0]
+		if price, ok := prices[key]; ok && price != "" && price != priceText(r) {
+			s.Sugar.Warnf("sale %s price mismatch: %s seen, %s now", key, price, priceText(r))
+		}
+	}
+	return nil
+}
+
+// mongoSeenStore keeps the keys in the seen collection, expired by the TTL index.
+type mongoSeenStore struct {
+	db *mongo.Database
+}
+
+func (m mongoSeenStore) insert(ctx context.Context, docs []seenDoc) (map[int]bool, error) {
+	values := make([]interface{}, 0, len(docs))
+	for _, d := range docs {
+		values = append(values, d)
+	}
+	duplicated := make(map[int]bool)
+	if _, err := m.db.Collection(collSeen).InsertMany(ctx, values, options.InsertMany().SetOrdered(false)); err != nil {
+		var bwe mongo.BulkWriteException
+		if !errors.As(err, &bwe) || bwe.WriteConcernError != nil {
+			return nil, err
+		}
+		for _, we := range bwe.WriteErrors {
+			if we.Code != errCodeDuplicateKey {
+				return nil, err
+			}
+			duplicated[we.Index] = true
+		}
+	}
+	return duplicated, nil
+}
+
+func (m mongoSeenStore) delete(ctx context.Context, keys []string) error {
+	_, err := m.db.Collection(collSeen).DeleteMany(ctx, bson.D{{"_id", bson.D{{"$in", keys}}}})
+	return err
+}
+
+func (m mongoSeenStore) prices(ctx context.Context, keys []string) (map[string]string, error) {
+	cur, err := m.db.Collection(collSeen).Find(ctx, bson.D{{"_id", bson.D{{"$in", keys}}}})
+	if err != nil {
+		return nil, err
+	}
+	var seen []seenDoc
+	if err = cur.All(ctx, &seen); err != nil {
+		return nil, err
+	}
+	prices := make(map[string]string, len(seen))
+	for _, d := range seen {
+		prices[d.Key] = d.Price
+	}
+	return prices, nil
+}
+
+// ensureTTLIndex creates the TTL index on field, or changes its expiration if it already exists.
+func (s *OpenSea) ensureTTLIndex(ctx context.Context, collName, indexName, field string, ttl time.Duration) error {
+	coll := s.db.Collection(collName)
+	indexView := coll.Indexes()
+	cursor, err := indexView.List(ctx, options.ListIndexes().SetMaxTime(time.Second*2))
+	if err != nil {
+		return err
+	}
+	var indexes []bson.M
+	if err = cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+	seconds := int32(ttl.Seconds())
+	for _, index := range indexes {
+		if index["name"] != indexName {
+			continue
+		}
+		if current, ok := index["expireAfterSeconds"]; ok && toInt64(current) == int64(seconds) {
+			return nil
+		}
+		if err = s.db.RunCommand(ctx, bson.D{
+			{"collMod", collName},
+			{"index", bson.D{
+				{"name", indexName},
+				{"expireAfterSeconds", seconds},
+			}},
+		}).Err(); err != nil {
+			return err
+		}
+		s.Sugar.Infof("change index %s expiration to %s", indexName, ttl)
+		return nil
+	}
+	index := mongo.IndexModel{
+		Keys:    bson.D{{field, 1}},
+		Options: options.Index().SetExpireAfterSeconds(seconds).SetName(indexName),
+	}
+	name, err := indexView.CreateOne(ctx, index)
+	if err != nil {
+		return err
+	}
+	s.Sugar.Infof("create index %s", name)
+	return nil
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,154 @@
+package opensea
+
+import (
+	"context"
+	"errors"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeenKeys(t *testing.T) {
+	tests := []struct {
+		ae   api.AssetEvent
+		want string
+	}{
+		{
+			api.AssetEvent{Id: 123, EventType: api.EventTypeList, FromAccount: &api.Account{}},
+			"123",
+		},
+		{
+			api.AssetEvent{
+				EventType:   api.EventTypeTransfer,
+				FromAccount: &api.Account{Address: "0x0000000000000000000000000000000000000000"},
+				Asset:       api.Asset{TokenId: "7", AssetContract: api.AssetContract{Address: "0x1a92f7381b9f03921564a437210bb9396471050c"}},
+				Transaction: &api.Transaction{TransactionHash: "0xabc"},
+			},
+			"0xabc:Mint:0x1A92f7381B9F03921564a437210bB9396471050C:7",
+		},
+		// same key as the transfer log on chain
+		{
+			api.AssetEvent{
+				Id:          456,
+				EventType:   api.EventTypeTransfer,
+				FromAccount: &api.Account{Address: "0x1a92f7381b9f03921564a437210bb9396471050c"},
+				Asset:       api.Asset{TokenId: "7", AssetContract: api.AssetContract{Address: "0x1a92f7381b9f03921564a437210bb9396471050c"}},
+				Transaction: &api.Transaction{TransactionHash: "0xABC"},
+			},
+			"0xabc:Transfer:0x1A92f7381B9F03921564a437210bB9396471050C:7",
+		},
+		{
+			api.AssetEvent{
+				Id:          789,
+				EventType:   api.EventTypeSale,
+				Asset:       api.Asset{TokenId: "7", AssetContract: api.AssetContract{Address: "0x1a92f7381b9f03921564a437210bb9396471050c"}},
+				Transaction: &api.Transaction{TransactionHash: "0xdef"},
+			},
+			"0xdef:Sale:0x1A92f7381B9F03921564a437210bB9396471050C:7",
+		},
+		// the event id, and the same order key as the streamed listing
+		{
+			api.AssetEvent{
+				Id:             321,
+				EventType:      api.EventTypeList,
+				EventTimestamp: "2021-09-01T08:00:00",
+				CreatedDate:    "2021-09-01T08:00:05",
+				FromAccount:    &api.Account{Address: "0x1a92f7381b9f03921564a437210bb9396471050c"},
+				Asset:          api.Asset{TokenId: "7", AssetContract: api.AssetContract{Address: "0x1a92f7381b9f03921564a437210bb9396471050c"}},
+				EndingPrice:    "1500000000000000000",
+				PaymentToken:   api.PaymentToken{Symbol: "ETH", Decimals: 18},
+			},
+			"321,0x1a92f7381b9f03921564a437210bb9396471050c:List:0x1A92f7381B9F03921564a437210bB9396471050C:7:1.5 ETH:1630483200",
+		},
+		{
+			api.AssetEvent{EventType: api.EventTypeBid, FromAccount: &api.Account{}},
+			"",
+		},
+	}
+	for i, tt := range tests {
+		if got := strings.Join(seenKeys(toRecord(tt.ae)), ","); got != tt.want {
+			t.Errorf("%d: seenKeys = %q, want %q", i, got, tt.want)
+		}
+	}
+	if got := strings.Join(seenKeys(Record{Source: "looksrare", EventId: "123", Event: EventList}), ","); got != "looksrare:123" {
+		t.Errorf("seenKeys of looksrare = %q", got)
+	}
+	// a streamed cancel has neither event id nor transaction
+	cancel := Record{Source: SourceOpenSea, Event: EventListCancel, Contract: "0x1A92f7381B9F03921564a437210bB9396471050C", Id: "7",
+		Time: time.Unix(1630483200, 0)}
+	if got := strings.Join(seenKeys(cancel), ","); got != ":List Cancel:0x1A92f7381B9F03921564a437210bB9396471050C:7::1630483200" {
+		t.Errorf("seenKeys of cancel = %q", got)
+	}
+}
+
+// memSeenStore is the seen collection in memory.
+type memSeenStore map[string]seenDoc
+
+func (m memSeenStore) insert(ctx context.Context, docs []seenDoc) (map[int]bool, error) {
+	duplicated := make(map[int]bool)
+	for i, d := range docs {
+		if _, ok := m[d.Key]; ok {
+			duplicated[i] = true
+			continue
+		}
+		m[d.Key] = d
+	}
+	return duplicated, nil
+}
+
+func (m memSeenStore) delete(ctx context.Context, keys []string) error {
+	for _, k := range keys {
+		delete(m, k)
+	}
+	return nil
+}
+
+func (m memSeenStore) prices(ctx context.Context, keys []string) (map[string]string, error) {
+	prices := make(map[string]string)
+	for _, k := range keys {
+		if d, ok := m[k]; ok {
+			prices[k] = d.Price
+		}
+	}
+	return prices, nil
+}
+
+func TestSaveFresh(t *testing.T) {
+	s := &OpenSea{seen: make(memSeenStore), Sugar: zap.NewNop().Sugar()}
+	records := []Record{{EventId: "1", Event: EventList}, {EventId: "2", Event: EventSale}, {Event: EventBid}}
+	var saved []Record
+	failed := func(ctx context.Context, records []Record) error {
+		return errors.New("save timeout")
+	}
+	save := func(ctx context.Context, records []Record) error {
+		saved = append(saved, records...)
+		return nil
+	}
+
+	poll := func(save eventSaver) error {
+		fresh, err := s.filterSeen(context.Background(), records)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.saveFresh(context.Background(), fresh, save)
+	}
+	// the failed events are delivered by the next poll
+	if err := poll(failed); err == nil {
+		t.Error("save error is lost")
+	}
+	if err := poll(save); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 3 {
+		t.Errorf("saved = %v", saved)
+	}
+	// seen after saved, the event without key is never seen
+	if err := poll(save); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 4 || saved[3].Event != EventBid {
+		t.Errorf("saved = %v", saved)
+	}
+}
@@ -0,0 +1,122 @@
+package opensea
+
+import (
+	"context"
+	"fmt"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.uber.org/zap"
+	"time"
+)
+
+const SourceTypeOpenSea = "opensea"
+
+// Query is what an event source fetches: a collection (by slug or contracts), a token, or a wallet.
+type Query struct {
+	Slug      string
+	Contracts []string // checksum addresses, all contracts of the collection if known
+	TokenId   string   // with one contract
+	Account   string
+	// Events are the events (Sale, List, ...) wanted, empty means all.
+	// It's only a hint, a source may return other events.
+	Events []string
+}
+
+// EventSource is a marketplace API the monitor polls, every target in windows of time.
+// The records are tagged by the source name, which is also the source of watermarks.
+type EventSource interface {
+	Name() string
+	// Supports returns false if the source can't fetch the query, e.g. by slug only.
+	Supports(q Query) bool
+	// Fetch fetches all events of the query in [from, to), no partial result on error.
+	Fetch(ctx context.Context, q Query, from, to time.Time) ([]Record, error)
+}
+
+// SourceConf registers an event source in the `sources` section of the config file.
+type SourceConf struct {
+	Type string `json:"type"` // adapter, e.g. opensea, looksrare
+	Name string `json:"name"` // tag of records, default Type
+	// API is the client config of the marketplace API, the `api` section for opensea.
+	API api.Config `json:"api"`
+}
+
+// SourceFactory creates an event source from its config.
+type SourceFactory func(conf SourceConf, sugar *zap.SugaredLogger) (EventSource, error)
+
+var sourceFactories = map[string]SourceFactory{
+	SourceTypeOpenSea:   newOpenSeaSource,
+	SourceTypeLooksRare: newLooksRareSource,
+}
+
+// RegisterSource registers the adapter of a source type, it must be called before Init.
+func RegisterSource(typ string, factory SourceFactory) {
+	sourceFactories[typ] = factory
+}
+
+// newSources creates the sources of the config, OpenSea only if none.
+// The opensea source without its own `api` uses the global one.
+func newSources(confs []SourceConf, global api.Config, sugar *zap.SugaredLogger) ([]EventSource, error) {
+	if len(confs) == 0 {
+		confs = []SourceConf{{Type: SourceTypeOpenSea}}
+	}
+	var sources []EventSource
+	names := make(map[string]bool)
+	for _, conf := range confs {
+		factory, ok := sourceFactories[conf.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown source type %s", conf.Type)
+		}
+		if conf.Name == "" {
+			conf.Name = conf.Type
+		}
+		if names[conf.Name] {
+			return nil, fmt.Errorf("source %s is registered twice", conf.Name)
+		}
+		names[conf.Name] = true
+		if conf.Type == SourceTypeOpenSea && conf.API == (api.Config{}) {
+			conf.API = global
+		}
+		source, err := factory(conf, sugar)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", conf.Name, err)
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// openSeaSource is the OpenSea events API.
+type openSeaSource struct {
+	name   string
+	client *api.Client
+	Sugar  *zap.SugaredLogger
+}
+
+func newOpenSeaSource(conf SourceConf, sugar *zap.SugaredLogger) (EventSource, error) {
+	client, err := api.New(conf.API)
+	if err != nil {
+		return nil, err
+	}
+	sugar.Infof("OpenSea API client initialized, endpoint: %s", client.BaseURL())
+	return &openSeaSource{name: conf.Name, client: client, Sugar: sugar}, nil
+}
+
+func (o *openSeaSource) Name() string {
+	return o.name
+}
+
+func (o *openSeaSource) Supports(q Query) bool {
+	return q.Slug != "" || len(q.Contracts) == 1 || q.Account != ""
+}
+
+func (o *openSeaSource) Fetch(ctx context.Context, q Query, from, to time.Time) ([]Record, error) {
+	eq := api.EventsQuery{
+		CollectionSlug: q.Slug,
+		TokenId:        q.TokenId,
+		AccountAddress: q.Account,
+		EventType:      apiEventType(q.Events),
+	}
+	if q.Slug == "" && len(q.Contracts) == 1 {
+		eq.AssetContractAddress = q.Contracts[0]
+	}
+	return fetchEvents(ctx, o.client, o.Sugar, eq, from, to)
+}
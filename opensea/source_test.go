@@ -0,0 +1,71 @@
+package opensea
+
+import (
+	"context"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+type mockSource struct {
+	name string
+}
+
+func (m *mockSource) Name() string {
+	return m.name
+}
+
+func (m *mockSource) Supports(q Query) bool {
+	return true
+}
+
+func (m *mockSource) Fetch(ctx context.Context, q Query, from, to time.Time) ([]Record, error) {
+	return nil, nil
+}
+
+func TestNewSources(t *testing.T) {
+	RegisterSource("mock", func(conf SourceConf, sugar *zap.SugaredLogger) (EventSource, error) {
+		return &mockSource{name: conf.Name}, nil
+	})
+	sugar := zap.NewNop().Sugar()
+	sources, err := newSources(nil, api.Config{}, sugar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[0].Name() != SourceOpenSea {
+		t.Errorf("default sources = %v", sources)
+	}
+	sources, err = newSources([]SourceConf{{Type: "opensea"}, {Type: "looksrare"}, {Type: "mock", Name: "other"}},
+		api.Config{}, sugar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 3 || sources[1].Name() != "looksrare" || sources[2].Name() != "other" {
+		t.Errorf("sources = %v", sources)
+	}
+	if _, err = newSources([]SourceConf{{Type: "rarible"}}, api.Config{}, sugar); err == nil {
+		t.Error("unknown type should be error")
+	}
+	if _, err = newSources([]SourceConf{{Type: "mock"}, {Type: "mock"}}, api.Config{}, sugar); err == nil {
+		t.Error("duplicated name should be error")
+	}
+}
+
+func TestOpenSeaSourceSupports(t *testing.T) {
+	o := &openSeaSource{name: SourceOpenSea}
+	tests := []struct {
+		q    Query
+		want bool
+	}{
+		{Query{Slug: "multi", Contracts: []string{"0x1", "0x2"}}, true},
+		{Query{Contracts: []string{"0x1"}, TokenId: "7"}, true},
+		{Query{Contracts: []string{"0x1", "0x2"}}, false},
+		{Query{Account: "0x3"}, true},
+	}
+	for i, tt := range tests {
+		if got := o.Supports(tt.q); got != tt.want {
+			t.Errorf("%d: supports = %v", i, got)
+		}
+	}
+}
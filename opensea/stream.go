@@ -0,0 +1,480 @@
+package opensea
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/websocket"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.uber.org/zap"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStreamHeartbeat  = time.Second * 30
+	defaultStreamMinBackoff = time.Second
+	defaultStreamMaxBackoff = time.Minute
+
+	// streamBuffer is the number of decoded events waiting for handle,
+	// events beyond are dropped and left to polling.
+	streamBuffer = 1024
+
+	// Phoenix channel protocol
+	phxTopic     = "phoenix"
+	phxHeartbeat = "heartbeat"
+	phxJoin      = "phx_join"
+	phxLeave     = "phx_leave"
+	phxReply     = "phx_reply"
+	phxError     = "phx_error"
+	phxClose     = "phx_close"
+)
+
+// streamEvents maps the event type of the stream to the event of Record,
+// other types (e.g. collection_offer) are ignored.
+var streamEvents = map[string]string{
+	"item_listed":         EventList,
+	"item_sold":           EventSale,
+	"item_transferred":    EventTransfer,
+	"item_received_offer": EventOffer,
+	"item_received_bid":   EventBid,
+	"item_cancelled":      EventListCancel,
+}
+
+// StreamConf enables the event stream of OpenSea, events are pushed as soon as they happen.
+// Polling goes on to fill the gaps when the stream is down.
+type StreamConf struct {
+	URL        string `json:"url"`        // e.g. wss://stream.openseabeta.com/socket/websocket, empty to disable
+	Key        string `json:"key"`        // sent as the `token` query, default the key of `api`
+	Heartbeat  string `json:"heartbeat"`  // heartbeat interval, the connection is dropped if not answered, default 30s
+	MinBackoff string `json:"minBackoff"` // first reconnect delay, doubled on every failure, default 1s
+	MaxBackoff string `json:"maxBackoff"` // reconnect delay upper bound, default 1m
+}
+
+// phxMessage is a message of the Phoenix channel protocol (vsn 1.0.0), in both directions.
+type phxMessage struct {
+	Topic   string          `json:"topic"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+	Ref     string          `json:"ref"` // null for pushes of the server
+}
+
+type phxReplyPayload struct {
+	Status   string          `json:"status"` // ok or error
+	Response json.RawMessage `json:"response"`
+}
+
+type streamEvent struct {
+	EventType string `json:"event_type"`
+	SentAt    string `json:"sent_at"`
+	Payload   struct {
+		Item struct {
+			NftId    string `json:"nft_id"` // chain/contract/id
+			Metadata struct {
+				Name     string `json:"name"`
+				ImageUrl string `json:"image_url"`
+			} `json:"metadata"`
+		} `json:"item"`
+		Collection struct {
+			Slug string `json:"slug"`
+		} `json:"collection"`
+		EventTimestamp string           `json:"event_timestamp"`
+		BasePrice      string           `json:"base_price"`
+		SalePrice      string           `json:"sale_price"`
+		PaymentToken   api.PaymentToken `json:"payment_token"`
+		Maker          *api.Account     `json:"maker"`
+		Taker          *api.Account     `json:"taker"`
+		FromAccount    *api.Account     `json:"from_account"`
+		ToAccount      *api.Account     `json:"to_account"`
+		Quantity       int64            `json:"quantity"`
+		Transaction    *struct {
+			Hash string `json:"hash"`
+		} `json:"transaction"`
+	} `json:"payload"`
+}
+
+// Stream subscribes to the events of collections over a Phoenix channel WebSocket, one topic per collection.
+// It reconnects with backoff when the connection is lost, and joins all topics again.
+type Stream struct {
+	url        string
+	heartbeat  time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu      sync.Mutex
+	topics  map[string]bool // topics wanted
+	changed chan struct{}
+
+	Sugar *zap.SugaredLogger
+}
+
+// NewStream creates the stream of the config, key is the default of conf.Key.
+func NewStream(conf StreamConf, key string, sugar *zap.SugaredLogger) (*Stream, error) {
+	u, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("stream url %s format error: %w", conf.URL, err)
+	}
+	if conf.Key != "" {
+		key = conf.Key
+	}
+	q := u.Query()
+	if key != "" {
+		q.Set("token", key)
+	}
+	q.Set("vsn", "1.0.0")
+	u.RawQuery = q.Encode()
+	s := &Stream{
+		url:     u.String(),
+		topics:  make(map[string]bool),
+		changed: make(chan struct{}, 1),
+		Sugar:   sugar,
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		def   time.Duration
+		ptr   *time.Duration
+	}{
+		{"heartbeat", conf.Heartbeat, defaultStreamHeartbeat, &s.heartbeat},
+		{"minBackoff", conf.MinBackoff, defaultStreamMinBackoff, &s.minBackoff},
+		{"maxBackoff", conf.MaxBackoff, defaultStreamMaxBackoff, &s.maxBackoff},
+	} {
+		*d.ptr = d.def
+		if d.value == "" {
+			continue
+		}
+		if *d.ptr, err = time.ParseDuration(d.value); err != nil {
+			return nil, fmt.Errorf("stream %s %s format error: %w", d.name, d.value, err)
+		}
+	}
+	if s.maxBackoff < s.minBackoff {
+		s.maxBackoff = s.minBackoff
+	}
+	return s, nil
+}
+
+func streamTopic(slug string) string {
+	return "collection:" + slug
+}
+
+// Subscribe sets the collections streamed, the topics are joined or left at once if connected.
+func (s *Stream) Subscribe(slugs []string) {
+	topics := make(map[string]bool, len(slugs))
+	for _, slug := range slugs {
+		topics[streamTopic(slug)] = true
+	}
+	s.mu.Lock()
+	s.topics = topics
+	s.mu.Unlock()
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Stream) wanted() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	topics := make(map[string]bool, len(s.topics))
+	for t := range s.topics {
+		topics[t] = true
+	}
+	return topics
+}
+
+// Run streams events to handle until ctx is done, one event at a time.
+// Events are handled by a worker of their own, a slow handle never delays the heartbeat.
+func (s *Stream) Run(ctx context.Context, handle func(ctx context.Context, r Record)) {
+	events := make(chan Record, streamBuffer)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case r := <-events:
+				handle(ctx, r)
+			}
+		}
+	}()
+	defer wg.Wait()
+
+	attempt := 0
+	for {
+		connected, err := s.session(ctx, events)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			attempt = 0
+		}
+		wait := api.Backoff(attempt, s.minBackoff, s.maxBackoff)
+		attempt++
+		s.Sugar.Errorf("stream error: %s, reconnect in %s", err, wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// session connects and streams until the connection is lost, returns false if not connected at all.
+// Topics failed to join are joined again on every heartbeat.
+// Decoded events are sent to events without blocking, they are dropped when it is full.
+func (s *Stream) session(ctx context.Context, events chan<- Record) (bool, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.url, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	s.Sugar.Info("stream connected")
+
+	done := make(chan struct{})
+	defer close(done)
+	messages := make(chan phxMessage)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			var m phxMessage
+			if err := conn.ReadJSON(&m); err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case messages <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ref := 0
+	push := func(topic, event string) (string, error) {
+		ref++
+		m := phxMessage{Topic: topic, Event: event, Payload: json.RawMessage("{}"), Ref: strconv.Itoa(ref)}
+		return m.Ref, conn.WriteJSON(m)
+	}
+	joined := make(map[string]bool)
+	joining := make(map[string]string) // ref to topic
+	resubscribe := func() error {
+		wanted := s.wanted()
+		pending := make(map[string]bool, len(joining))
+		for _, t := range joining {
+			pending[t] = true
+		}
+		var topics []string
+		for t := range wanted {
+			if !joined[t] && !pending[t] {
+				topics = append(topics, t)
+			}
+		}
+		sort.Strings(topics)
+		for _, t := range topics {
+			r, err := push(t, phxJoin)
+			if err != nil {
+				return err
+			}
+			joining[r] = t
+		}
+		for t := range joined {
+			if !wanted[t] {
+				if _, err := push(t, phxLeave); err != nil {
+					return err
+				}
+				delete(joined, t)
+				s.Sugar.Infof("stream left %s", t)
+			}
+		}
+		return nil
+	}
+	if err = resubscribe(); err != nil {
+		return true, err
+	}
+
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	heartbeat := "" // ref of the heartbeat not answered
+	for {
+		select {
+		case <-ctx.Done():
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return true, ctx.Err()
+		case err = <-readErr:
+			return true, err
+		case <-s.changed:
+			if err = resubscribe(); err != nil {
+				return true, err
+			}
+		case <-ticker.C:
+			if heartbeat != "" {
+				return true, fmt.Errorf("heartbeat %s timeout", heartbeat)
+			}
+			if heartbeat, err = push(phxTopic, phxHeartbeat); err != nil {
+				return true, err
+			}
+			if err = resubscribe(); err != nil {
+				return true, err
+			}
+		case m := <-messages:
+			switch m.Event {
+			case phxReply:
+				if m.Ref == heartbeat {
+					heartbeat = ""
+					continue
+				}
+				t, ok := joining[m.Ref]
+				if !ok {
+					continue
+				}
+				delete(joining, m.Ref)
+				var reply phxReplyPayload
+				if err = json.Unmarshal(m.Payload, &reply); err != nil || reply.Status != "ok" {
+					s.Sugar.Errorf("stream join %s error: %s %s", t, reply.Status, reply.Response)
+					continue
+				}
+				joined[t] = true
+				s.Sugar.Infof("stream joined %s", t)
+			case phxError, phxClose:
+				if joined[m.Topic] {
+					// joined again on the next heartbeat
+					delete(joined, m.Topic)
+					s.Sugar.Warnf("stream topic %s closed: %s", m.Topic, m.Event)
+				}
+			default:
+				var e streamEvent
+				if err = json.Unmarshal(m.Payload, &e); err != nil {
+					s.Sugar.Errorf("stream event %s of %s format error: %s", m.Event, m.Topic, err)
+					continue
+				}
+				r, ok := fromStream(e)
+				if !ok {
+					continue
+				}
+				select {
+				case events <- r:
+				default:
+					s.Sugar.Warnf("stream buffer full, %s of %s #%s dropped", r.Event, r.Slug, r.Id)
+				}
+			}
+		}
+	}
+}
+
+// fromStream converts a streamed event of an Ethereum NFT to a record.
+// The maker of the order is the From of List, Offer, Bid and Sale, the taker is the To of Sale.
+func fromStream(e streamEvent) (Record, bool) {
+	event, ok := streamEvents[e.EventType]
+	if !ok {
+		return Record{}, false
+	}
+	p := e.Payload
+	parts := strings.Split(p.Item.NftId, "/")
+	if len(parts) != 3 || parts[0] != "ethereum" || !common.IsHexAddress(parts[1]) {
+		return Record{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, p.EventTimestamp)
+	if err != nil {
+		t = time.Now()
+	}
+	r := Record{
+		Source:          SourceOpenSea,
+		Slug:            p.Collection.Slug,
+		Contract:        common.HexToAddress(parts[1]).Hex(),
+		Name:            p.Item.Metadata.Name,
+		Id:              parts[2],
+		Event:           event,
+		Quantity:        p.Quantity,
+		Time:            t,
+		ImagePreviewUrl: p.Item.Metadata.ImageUrl,
+		CreatedAt:       time.Now(),
+	}
+	if r.Quantity <= 0 {
+		r.Quantity = 1
+	}
+	if p.Transaction != nil {
+		r.TxHash = p.Transaction.Hash
+	}
+	switch event {
+	case EventTransfer:
+		r.FromName, r.FromAddress = account(p.FromAccount)
+		r.ToName, r.ToAddress = account(p.ToAccount)
+		if r.FromAddress == (common.Address{}).Hex() {
+			r.Event = EventMint
+		}
+	case EventSale:
+		r.setPrice(p.SalePrice, token(p.PaymentToken))
+		r.FromName, r.FromAddress = account(p.Maker)
+		r.ToName, r.ToAddress = account(p.Taker)
+	default:
+		r.setPrice(p.BasePrice, token(p.PaymentToken))
+		r.FromName, r.FromAddress = account(p.Maker)
+	}
+	return r, true
+}
+
+// streamState is what the stream handler uses of the latest polling round.
+type streamState struct {
+	projects map[string]Project // by slug
+	wallets  wallets
+}
+
+// subscribe streams the projects with slug, projects without slug are only polled.
+func (s *OpenSea) subscribe(topN map[string]Project, tracked wallets) {
+	state := streamState{projects: make(map[string]Project), wallets: tracked}
+	var slugs []string
+	for _, p := range topN {
+		if p.Slug != "" {
+			state.projects[p.Slug] = p
+			slugs = append(slugs, p.Slug)
+		}
+	}
+	sort.Strings(slugs)
+	s.streamState.Store(state)
+	s.stream.Subscribe(slugs)
+}
+
+// handleStream saves a streamed event like a polled one,
+// the same event polled later is suppressed as seen.
+func (s *OpenSea) handleStream(ctx context.Context, r Record) {
+	state, _ := s.streamState.Load().(streamState)
+	p, ok := state.projects[r.Slug]
+	if !ok {
+		// left before unsubscribed
+		return
+	}
+	r.Collection = p.Name
+	records := []Record{r}
+	state.wallets.mark(records)
+	s.rates.update(records)
+	records, err := s.filterSeen(ctx, records)
+	if err != nil {
+		s.Sugar.Errorf("filter seen stream event error: %s", err)
+		return
+	}
+	if s.floors != nil {
+		s.floors.stamp(ctx, records, r.Slug)
+	}
+	if s.traits != nil {
+		// the listings are updated by all events, including the ones not wanted
+		s.traits.stamp(ctx, records, r.Slug)
+	}
+	if s.rarities != nil {
+		s.rarities.stamp(ctx, records)
+	}
+	records = s.eventTypes.filter(records)
+	if err = s.saveFresh(ctx, records, s.saveEvent); err != nil {
+		s.Sugar.Errorf("save stream event error: %s", err)
+		return
+	}
+	if len(records) > 0 {
+		s.Sugar.Debugf("stream event saved: %s %s %s #%s", r.Event, r.Slug, r.Contract, r.Id)
+	}
+}
@@ -0,0 +1,243 @@
+package opensea
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const listedEvent = `{"event_type": "item_listed", "sent_at": "2022-10-01T00:00:01.000000+00:00", "payload": {
+	"item": {"nft_id": "ethereum/0x1a92f7381b9f03921564a437210bb9396471050c/7", "metadata": {"name": "Cat #7", "image_url": "https://img/7"}},
+	"collection": {"slug": "a"},
+	"event_timestamp": "2022-10-01T00:00:00.000000+00:00",
+	"base_price": "1500000000000000000",
+	"payment_token": {"symbol": "ETH", "decimals": 18},
+	"maker": {"address": "0x1a92f7381b9f03921564a437210bb9396471050c"}}}`
+
+// phxConn is a connection of the stand-in Phoenix server, written by the handler and the test.
+type phxConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *phxConn) send(m phxMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(m)
+}
+
+// phxServer answers joins and heartbeats, other messages of the client are received by the test.
+type phxServer struct {
+	*httptest.Server
+	tokens   chan string
+	conns    chan *phxConn
+	received chan phxMessage
+}
+
+func newPhxServer(t *testing.T) *phxServer {
+	s := &phxServer{
+		tokens:   make(chan string, 10),
+		conns:    make(chan *phxConn, 10),
+		received: make(chan phxMessage, 100),
+	}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade error: %s", err)
+			return
+		}
+		c := &phxConn{conn: conn}
+		s.tokens <- r.URL.Query().Get("token")
+		s.conns <- c
+		for {
+			var m phxMessage
+			if err := conn.ReadJSON(&m); err != nil {
+				return
+			}
+			if m.Event == phxJoin || m.Event == phxHeartbeat {
+				_ = c.send(phxMessage{Topic: m.Topic, Event: phxReply, Ref: m.Ref,
+					Payload: json.RawMessage(`{"status": "ok", "response": {}}`)})
+			}
+			if m.Event != phxHeartbeat {
+				s.received <- m
+			}
+		}
+	}))
+	return s
+}
+
+func (s *phxServer) expect(t *testing.T, event, topic string) {
+	t.Helper()
+	select {
+	case m := <-s.received:
+		if m.Event != event || m.Topic != topic {
+			t.Fatalf("received %s %s, want %s %s", m.Event, m.Topic, event, topic)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("%s %s not received", event, topic)
+	}
+}
+
+func (s *phxServer) conn(t *testing.T) *phxConn {
+	t.Helper()
+	select {
+	case c := <-s.conns:
+		if token := <-s.tokens; token != "key" {
+			t.Errorf("token = %q", token)
+		}
+		return c
+	case <-time.After(time.Second * 2):
+		t.Fatal("not connected")
+	}
+	return nil
+}
+
+func TestStream(t *testing.T) {
+	srv := newPhxServer(t)
+	defer srv.Close()
+	stream, err := NewStream(StreamConf{
+		URL:        "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Heartbeat:  "50ms",
+		MinBackoff: "10ms",
+		MaxBackoff: "20ms",
+	}, "key", zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records := make(chan Record, 10)
+	stream.Subscribe([]string{"b", "a"})
+	go stream.Run(ctx, func(ctx context.Context, r Record) {
+		records <- r
+	})
+
+	conn := srv.conn(t)
+	srv.expect(t, phxJoin, "collection:a")
+	srv.expect(t, phxJoin, "collection:b")
+	if err = conn.send(phxMessage{Topic: "collection:a", Event: "item_listed", Payload: json.RawMessage(listedEvent)}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-records:
+		if r.Source != SourceOpenSea || r.Slug != "a" || r.Contract != "0x1A92f7381B9F03921564a437210bB9396471050C" ||
+			r.Id != "7" || r.Event != EventList || priceText(r) != "1.5 ETH" || r.FromAddress != r.Contract {
+			t.Errorf("record = %+v", r)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("event not streamed")
+	}
+
+	stream.Subscribe([]string{"a"})
+	srv.expect(t, phxLeave, "collection:b")
+
+	// the connection is lost, the stream reconnects and joins again
+	_ = conn.conn.Close()
+	srv.conn(t)
+	srv.expect(t, phxJoin, "collection:a")
+	select {
+	case m := <-srv.received:
+		t.Errorf("unexpected %s %s", m.Event, m.Topic)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestStreamSlowHandle(t *testing.T) {
+	srv := newPhxServer(t)
+	defer srv.Close()
+	stream, err := NewStream(StreamConf{
+		URL:        "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Heartbeat:  "50ms",
+		MinBackoff: "10ms",
+		MaxBackoff: "20ms",
+	}, "key", zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	release := make(chan struct{})
+	records := make(chan Record, 10)
+	stream.Subscribe([]string{"a"})
+	go stream.Run(ctx, func(ctx context.Context, r Record) {
+		<-release
+		records <- r
+	})
+
+	conn := srv.conn(t)
+	srv.expect(t, phxJoin, "collection:a")
+	for i := 0; i < 2; i++ {
+		if err = conn.send(phxMessage{Topic: "collection:a", Event: "item_listed", Payload: json.RawMessage(listedEvent)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// heartbeats are answered while handle is blocked, the connection is kept
+	select {
+	case <-srv.conns:
+		t.Fatal("reconnected while handling")
+	case <-time.After(time.Millisecond * 300):
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-records:
+		case <-time.After(time.Second * 2):
+			t.Fatal("event not handled")
+		}
+	}
+}
+
+func TestFromStream(t *testing.T) {
+	contract := "0x1A92f7381B9F03921564a437210bB9396471050C"
+	zero := "0x0000000000000000000000000000000000000000"
+	tests := []struct {
+		event string
+		ok    bool
+		want  Record
+		price string
+	}{
+		{listedEvent, true, Record{Event: EventList, FromAddress: contract}, "1.5 ETH"},
+		{`{"event_type": "item_transferred", "payload": {"item": {"nft_id": "ethereum/` + contract + `/8"},
+			"from_account": {"address": "` + zero + `"}, "to_account": {"address": "` + contract + `"},
+			"transaction": {"hash": "0xabc"}}}`,
+			true, Record{Event: EventMint, Id: "8", FromAddress: zero, ToAddress: contract, TxHash: "0xabc"}, ""},
+		{`{"event_type": "item_sold", "payload": {"item": {"nft_id": "ethereum/` + contract + `/9"},
+			"sale_price": "2000000", "payment_token": {"symbol": "USDC", "decimals": 6},
+			"maker": {"address": "` + contract + `"}, "taker": {"address": "` + zero + `"},
+			"transaction": {"hash": "0xdef"}}}`,
+			true, Record{Event: EventSale, Id: "9", FromAddress: contract, ToAddress: zero, TxHash: "0xdef"}, "2 USDC"},
+		{`{"event_type": "item_listed", "payload": {"item": {"nft_id": "matic/` + contract + `/7"}}}`, false, Record{}, ""},
+		{`{"event_type": "item_cancelled", "payload": {"item": {"nft_id": "ethereum/` + contract + `/7"},
+			"maker": {"address": "` + contract + `"}}}`,
+			true, Record{Event: EventListCancel, FromAddress: contract}, ""},
+		{`{"event_type": "collection_offer", "payload": {"item": {"nft_id": "ethereum/` + contract + `/7"}}}`, false, Record{}, ""},
+	}
+	for i, tt := range tests {
+		var e streamEvent
+		if err := json.Unmarshal([]byte(tt.event), &e); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		r, ok := fromStream(e)
+		if ok != tt.ok {
+			t.Errorf("%d: ok = %v", i, ok)
+		}
+		if !ok {
+			continue
+		}
+		if tt.want.Id == "" {
+			tt.want.Id = "7"
+		}
+		if r.Contract != contract || r.Event != tt.want.Event || r.Id != tt.want.Id || priceText(r) != tt.price ||
+			r.FromAddress != tt.want.FromAddress || r.ToAddress != tt.want.ToAddress || r.TxHash != tt.want.TxHash {
+			t.Errorf("%d: record = %+v", i, r)
+		}
+	}
+}
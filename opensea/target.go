@@ -0,0 +1,104 @@
+package opensea
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"sort"
+)
+
+// target is polled with its own watermark and schedule, either a whole project or one token.
+type target struct {
+	key   string // collection:<slug>, project address, asset:<contract>:<tokenId> or wallet:<address>
+	query Query
+	// legacy is the address key of a project before it had slug, its watermark is the fallback.
+	legacy string
+}
+
+func collectionKey(slug string) string {
+	return "collection:" + slug
+}
+
+func assetKey(contract, tokenId string) string {
+	return "asset:" + contract + ":" + tokenId
+}
+
+// targets returns the projects, then the tokens watched by chats, each only once.
+// The tracked wallets are not included.
+// A project is requested by slug or by its contracts, with the events wanted by chats.
+// A token is requested with all events.
+func (s *OpenSea) targets(topN map[string]Project, chats []Configuration) []target {
+	var targets []target
+	for _, key := range sortedKeys(topN) {
+		p := topN[key]
+		t := target{
+			key: key,
+			query: Query{
+				Slug:      p.Slug,
+				Contracts: p.contracts(),
+				Events:    wantedEvents(s.eventTypes, chats, p, s.traits != nil),
+			},
+		}
+		if p.Slug != "" {
+			t.legacy = p.Address
+		}
+		targets = append(targets, t)
+	}
+	assets := make(map[string]target)
+	for _, chat := range chats {
+		for _, a := range chat.Assets {
+			if a.Contract == "" || a.TokenId == "" {
+				continue
+			}
+			contract := common.HexToAddress(a.Contract).Hex()
+			key := assetKey(contract, a.TokenId)
+			assets[key] = target{
+				key:   key,
+				query: Query{Contracts: []string{contract}, TokenId: a.TokenId},
+			}
+		}
+	}
+	keys := make([]string, 0, len(assets))
+	for k := range assets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		targets = append(targets, assets[k])
+	}
+	return targets
+}
+
+// trackedWallets returns the global wallets and the wallets of all chats.
+func (s *OpenSea) trackedWallets(chats []Configuration) wallets {
+	tracked := make(wallets, len(s.wallets))
+	for a := range s.wallets {
+		tracked[a] = true
+	}
+	for _, chat := range chats {
+		tracked.add(chat.Wallets)
+	}
+	return tracked
+}
+
+// monitored is the index of the projects of a round, by slug and by contract.
+type monitored struct {
+	slugs     map[string]bool
+	contracts map[string]bool
+}
+
+func newMonitored(projects map[string]Project) monitored {
+	m := monitored{slugs: make(map[string]bool), contracts: make(map[string]bool)}
+	for _, p := range projects {
+		if p.Slug != "" {
+			m.slugs[p.Slug] = true
+		}
+		for _, a := range p.contracts() {
+			m.contracts[a] = true
+		}
+	}
+	return m
+}
+
+// has returns true if the record belongs to a monitored project.
+func (m monitored) has(r Record) bool {
+	return (r.Slug != "" && m.slugs[r.Slug]) || m.contracts[r.Contract]
+}
@@ -0,0 +1,80 @@
+package opensea
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTargets(t *testing.T) {
+	s := &OpenSea{}
+	topN := map[string]Project{
+		"0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D": {Address: "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"},
+		"collection:multi": {
+			Slug:      "multi",
+			Address:   "0x0000000000000000000000000000000000000002",
+			Addresses: []string{"0x0000000000000000000000000000000000000002", "0x0000000000000000000000000000000000000003"},
+		},
+	}
+	chats := []Configuration{
+		{
+			EventTypes: []string{EventSale},
+			Assets:     []AssetConf{{Contract: "0x1a92f7381b9f03921564a437210bb9396471050c", TokenId: "42"}},
+		},
+		{
+			Projects: []ProjectConf{{Address: "0x1a92f7381b9f03921564a437210bb9396471050c"}},
+			Assets: []AssetConf{
+				{Contract: "0x1A92f7381B9F03921564a437210bB9396471050C", TokenId: "42"},
+				{Contract: "0x1A92f7381B9F03921564a437210bB9396471050C"},
+			},
+		},
+	}
+	want := []target{
+		{
+			key:   "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D",
+			query: Query{Contracts: []string{"0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"}, Events: []string{EventSale}},
+		},
+		{
+			key: "collection:multi",
+			query: Query{
+				Slug:      "multi",
+				Contracts: []string{"0x0000000000000000000000000000000000000002", "0x0000000000000000000000000000000000000003"},
+				Events:    []string{EventSale},
+			},
+			legacy: "0x0000000000000000000000000000000000000002",
+		},
+		{
+			key:   "asset:0x1A92f7381B9F03921564a437210bB9396471050C:42",
+			query: Query{Contracts: []string{"0x1A92f7381B9F03921564a437210bB9396471050C"}, TokenId: "42"},
+		},
+	}
+	got := s.targets(topN, chats)
+	if len(got) != len(want) {
+		t.Fatalf("%d targets, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("%d: %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMonitored(t *testing.T) {
+	m := newMonitored(map[string]Project{
+		"collection:multi":                           {Slug: "multi", Addresses: []string{"0x0000000000000000000000000000000000000002"}},
+		"0x0000000000000000000000000000000000000004": {Address: "0x0000000000000000000000000000000000000004"},
+	})
+	tests := []struct {
+		r    Record
+		want bool
+	}{
+		{Record{Slug: "multi", Contract: "0x0000000000000000000000000000000000000003"}, true},
+		{Record{Contract: "0x0000000000000000000000000000000000000002"}, true},
+		{Record{Slug: "other", Contract: "0x0000000000000000000000000000000000000004"}, true},
+		{Record{Slug: "other", Contract: "0x0000000000000000000000000000000000000005"}, false},
+	}
+	for i, tt := range tests {
+		if got := m.has(tt.r); got != tt.want {
+			t.Errorf("%d: has = %v", i, got)
+		}
+	}
+}
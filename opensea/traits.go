@@ -0,0 +1,373 @@
+package opensea
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	collTraits   = "traits"
+	collListings = "listings"
+
+	listingExpireIndexName = "listingExpireIndex"
+
+	defaultListingTTL = time.Hour * 72
+	defaultMaxFetch   = 20
+)
+
+// TraitConf is the `traits` section, the traits of tokens are cached and the floor price of every trait
+// is kept from the active listings, for below-trait-floor alerts.
+type TraitConf struct {
+	Enabled bool `json:"enabled"`
+	// ListingTTL is how long a listing is active if the token is not sold or transferred, default 72h.
+	ListingTTL string `json:"listingTTL"`
+	// MaxFetch is the max number of tokens whose traits are fetched from the API in one batch, default 20.
+	MaxFetch int `json:"maxFetch"`
+}
+
+// listing is an active listing of a token, by the latest List event of the token.
+type listing struct {
+	Key      string    `bson:"_id"` // assetKey
+	Slug     string    `bson:"slug"`
+	Contract string    `bson:"contract"`
+	Id       string    `bson:"id"`
+	ETH      *Decimal  `bson:"eth"`
+	Traits   []Trait   `bson:"traits"`
+	ListedAt time.Time `bson:"listedAt"`
+}
+
+// cachedTraits is a token in the traits collection, with its rarity if ranked.
+type cachedTraits struct {
+	Key    string  `bson:"_id"` // assetKey
+	Traits []Trait `bson:"traits"`
+	Rarity *Rarity `bson:"rarity,omitempty"`
+}
+
+// traitStore saves the traits of tokens and the active listings.
+type traitStore interface {
+	// loadTraits returns the known tokens by assetKey.
+	loadTraits(ctx context.Context, keys []string) (map[string]cachedTraits, error)
+	saveTraits(ctx context.Context, r Record, traits []Trait) error
+	// loadListings returns the listings listed after since.
+	loadListings(ctx context.Context, since time.Time) ([]listing, error)
+	saveListing(ctx context.Context, l listing) error
+	deleteListing(ctx context.Context, key string) error
+}
+
+// traitFetcher fetches the traits of the token.
+type traitFetcher func(ctx context.Context, contract, tokenId string) ([]Trait, error)
+
+// assetTraits fetches the traits from the asset API of OpenSea.
+func assetTraits(client *api.Client) traitFetcher {
+	return func(ctx context.Context, contract, tokenId string) ([]Trait, error) {
+		asset, err := client.Asset(ctx, contract, tokenId)
+		if err != nil {
+			return nil, err
+		}
+		return traits(asset.Traits), nil
+	}
+}
+
+// traitBook fills the traits of records and keeps the active listings of every collection by slug,
+// the listings with traits are kept in memory and saved in the store.
+type traitBook struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxFetch int
+	store    traitStore
+	fetch    traitFetcher
+	listings map[string]map[string]listing // by slug and assetKey
+	Sugar    *zap.SugaredLogger
+}
+
+func newTraitBook(ttl time.Duration, maxFetch int, store traitStore, fetch traitFetcher, sugar *zap.SugaredLogger) *traitBook {
+	return &traitBook{
+		ttl:      ttl,
+		maxFetch: maxFetch,
+		store:    store,
+		fetch:    fetch,
+		listings: make(map[string]map[string]listing),
+		Sugar:    sugar,
+	}
+}
+
+// load loads the active listings saved before.
+func (b *traitBook) load(ctx context.Context) error {
+	listings, err := b.store.loadListings(ctx, time.Now().Add(-b.ttl))
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, l := range listings {
+		b.slugListings(l.Slug)[l.Key] = l
+	}
+	return nil
+}
+
+func (b *traitBook) slugListings(slug string) map[string]listing {
+	m, ok := b.listings[slug]
+	if !ok {
+		m = make(map[string]listing)
+		b.listings[slug] = m
+	}
+	return m
+}
+
+// stamp fills the traits of the records, updates the active listings in time order,
+// and sets the trait floor on the listings valued in ETH.
+// slug is the collection of the records without slug, e.g. from LooksRare.
+func (b *traitBook) stamp(ctx context.Context, records []Record, slug string) {
+	b.fill(ctx, records, slug)
+	// records are newest first
+	for i := len(records) - 1; i >= 0; i-- {
+		r := &records[i]
+		s := r.Slug
+		if s == "" {
+			s = slug
+		}
+		if s == "" || r.Id == "" {
+			continue
+		}
+		key := assetKey(r.Contract, r.Id)
+		// replayed and caught-up events are as old as they happened
+		at := r.Time
+		if at.IsZero() {
+			at = time.Now()
+		}
+		switch r.Event {
+		case EventSale, EventTransfer, EventMint, EventListCancel:
+			b.unlist(ctx, s, key, at)
+		case EventList:
+			if r.ETH == nil || len(r.Traits) == 0 {
+				continue
+			}
+			l := listing{Key: key, Slug: s, Contract: r.Contract, Id: r.Id, ETH: r.ETH, Traits: r.Traits, ListedAt: at}
+			r.TraitFloor = b.list(ctx, l)
+		}
+	}
+}
+
+// fill sets the traits of the records without traits, from the store or fetched from the API.
+// The tokens of listings and sales are fetched, at most maxFetch tokens.
+func (b *traitBook) fill(ctx context.Context, records []Record, slug string) {
+	var keys []string
+	for _, r := range records {
+		if r.Id != "" {
+			keys = append(keys, assetKey(r.Contract, r.Id))
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	cached, err := b.store.loadTraits(ctx, keys)
+	if err != nil {
+		b.Sugar.Errorf("load traits error: %s", err)
+	}
+	known := make(map[string][]Trait)
+	for k, token := range cached {
+		known[k] = token.Traits
+	}
+	fetched := make(map[string]bool)
+	for i := range records {
+		r := &records[i]
+		if r.Id == "" {
+			continue
+		}
+		key := assetKey(r.Contract, r.Id)
+		if len(r.Traits) > 0 {
+			continue
+		}
+		if len(known[key]) == 0 && !fetched[key] && len(fetched) < b.maxFetch && (r.Event == EventList || r.Event == EventSale) {
+			fetched[key] = true
+			traits, err := b.fetch(ctx, r.Contract, r.Id)
+			if err != nil {
+				b.Sugar.Errorf("fetch traits of %s error: %s", key, err)
+			} else if len(traits) > 0 {
+				// unrevealed tokens have no traits, fetched again next time
+				token := *r
+				if token.Slug == "" {
+					token.Slug = slug
+				}
+				if err = b.store.saveTraits(ctx, token, traits); err != nil {
+					b.Sugar.Errorf("save traits of %s error: %s", key, err)
+				}
+			}
+			known[key] = traits
+		}
+		r.Traits = known[key]
+	}
+}
+
+// list saves the listing and returns the trait floor of the token before it.
+// The listing is not kept if expired, or older than the known listing of the token.
+func (b *traitBook) list(ctx context.Context, l listing) *TraitFloor {
+	b.mu.Lock()
+	floor := b.traitFloor(l)
+	listings := b.slugListings(l.Slug)
+	current, ok := listings[l.Key]
+	active := l.ListedAt.After(time.Now().Add(-b.ttl)) && (!ok || !current.ListedAt.After(l.ListedAt))
+	if active {
+		listings[l.Key] = l
+	}
+	b.mu.Unlock()
+	if !active {
+		return floor
+	}
+	if err := b.store.saveListing(ctx, l); err != nil {
+		b.Sugar.Errorf("save listing %s error: %s", l.Key, err)
+	}
+	return floor
+}
+
+// unlist removes the listing of the sold, transferred or cancelled token, if listed before at.
+func (b *traitBook) unlist(ctx context.Context, slug, key string, at time.Time) {
+	b.mu.Lock()
+	l, ok := b.listings[slug][key]
+	ok = ok && !l.ListedAt.After(at)
+	if ok {
+		delete(b.listings[slug], key)
+	}
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := b.store.deleteListing(ctx, key); err != nil {
+		b.Sugar.Errorf("delete listing %s error: %s", key, err)
+	}
+}
+
+// traitFloor returns the highest floor price of the traits of the listing, among the other active listings,
+// nil if no other listing has any of the traits. The expired listings are removed.
+func (b *traitBook) traitFloor(l listing) *TraitFloor {
+	listings := b.listings[l.Slug]
+	floors := make([]*Decimal, len(l.Traits))
+	expired := time.Now().Add(-b.ttl)
+	for key, other := range listings {
+		if other.ListedAt.Before(expired) {
+			delete(listings, key)
+			continue
+		}
+		if key == l.Key {
+			continue
+		}
+		for i, t := range l.Traits {
+			if floors[i] != nil && !other.ETH.LessThan(floors[i].Decimal) {
+				continue
+			}
+			if hasTrait(other.Traits, t) {
+				floors[i] = other.ETH
+			}
+		}
+	}
+	var floor *TraitFloor
+	for i, f := range floors {
+		if f != nil && (floor == nil || f.GreaterThan(floor.Price.Decimal)) {
+			floor = &TraitFloor{Type: l.Traits[i].Type, Value: l.Traits[i].Value, Price: f}
+		}
+	}
+	return floor
+}
+
+func hasTrait(traits []Trait, t Trait) bool {
+	for _, o := range traits {
+		if strings.EqualFold(o.Type, t.Type) && strings.EqualFold(o.Value, t.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// mongoTraitStore saves the traits in the traits collection, and the listings in the listings collection.
+type mongoTraitStore struct {
+	db *mongo.Database
+}
+
+func (m mongoTraitStore) loadTraits(ctx context.Context, keys []string) (map[string]cachedTraits, error) {
+	cur, err := m.db.Collection(collTraits).Find(ctx, bson.D{{"_id", bson.D{{"$in", keys}}}})
+	if err != nil {
+		return nil, err
+	}
+	var docs []cachedTraits
+	if err = cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]cachedTraits, len(docs))
+	for _, d := range docs {
+		tokens[d.Key] = d
+	}
+	return tokens, nil
+}
+
+func (m mongoTraitStore) saveTraits(ctx context.Context, r Record, traits []Trait) error {
+	_, err := m.db.Collection(collTraits).UpdateOne(ctx,
+		bson.D{{"_id", assetKey(r.Contract, r.Id)}},
+		bson.D{{"$set", bson.D{
+			{"slug", r.Slug},
+			{"contract", r.Contract},
+			{"id", r.Id},
+			{"traits", traits},
+			{"updatedAt", time.Now()},
+		}}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (m mongoTraitStore) loadListings(ctx context.Context, since time.Time) ([]listing, error) {
+	cur, err := m.db.Collection(collListings).Find(ctx, bson.D{{"listedAt", bson.D{{"$gt", since}}}})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var listings []listing
+	if err = cur.All(ctx, &listings); err != nil {
+		return nil, err
+	}
+	return listings, nil
+}
+
+func (m mongoTraitStore) saveListing(ctx context.Context, l listing) error {
+	_, err := m.db.Collection(collListings).ReplaceOne(ctx, bson.D{{"_id", l.Key}}, l, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m mongoTraitStore) deleteListing(ctx context.Context, key string) error {
+	_, err := m.db.Collection(collListings).DeleteOne(ctx, bson.D{{"_id", key}})
+	return err
+}
+
+// initTraits creates the trait book, and loads the active listings.
+func (s *OpenSea) initTraits(ctx context.Context) error {
+	ttl := defaultListingTTL
+	if s.cfg.Traits.ListingTTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(s.cfg.Traits.ListingTTL); err != nil {
+			return fmt.Errorf("traits listingTTL %s format error: %w", s.cfg.Traits.ListingTTL, err)
+		}
+	}
+	maxFetch := s.cfg.Traits.MaxFetch
+	if maxFetch <= 0 {
+		maxFetch = defaultMaxFetch
+	}
+	client, err := s.openSeaClient()
+	if err != nil {
+		return err
+	}
+	if err = s.ensureTTLIndex(ctx, collListings, listingExpireIndexName, "listedAt", ttl); err != nil {
+		return err
+	}
+	s.traits = newTraitBook(ttl, maxFetch, mongoTraitStore{db: s.db}, assetTraits(client), s.Sugar)
+	return s.traits.load(ctx)
+}
@@ -0,0 +1,168 @@
+package opensea
+
+import (
+	"context"
+	"errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memTraitStore is a traitStore in memory.
+type memTraitStore struct {
+	traits   map[string][]Trait
+	rarities map[string]*Rarity
+	listings map[string]listing
+}
+
+func newMemTraitStore() *memTraitStore {
+	return &memTraitStore{traits: make(map[string][]Trait), rarities: make(map[string]*Rarity), listings: make(map[string]listing)}
+}
+
+func (m *memTraitStore) loadTraits(ctx context.Context, keys []string) (map[string]cachedTraits, error) {
+	tokens := make(map[string]cachedTraits)
+	for _, k := range keys {
+		if t, ok := m.traits[k]; ok {
+			tokens[k] = cachedTraits{Key: k, Traits: t, Rarity: m.rarities[k]}
+		}
+	}
+	return tokens, nil
+}
+
+func (m *memTraitStore) saveTraits(ctx context.Context, r Record, traits []Trait) error {
+	m.traits[assetKey(r.Contract, r.Id)] = traits
+	return nil
+}
+
+func (m *memTraitStore) loadListings(ctx context.Context, since time.Time) ([]listing, error) {
+	var listings []listing
+	for _, l := range m.listings {
+		if l.ListedAt.After(since) {
+			listings = append(listings, l)
+		}
+	}
+	return listings, nil
+}
+
+func (m *memTraitStore) saveListing(ctx context.Context, l listing) error {
+	m.listings[l.Key] = l
+	return nil
+}
+
+func (m *memTraitStore) deleteListing(ctx context.Context, key string) error {
+	delete(m.listings, key)
+	return nil
+}
+
+func TestTraitBook(t *testing.T) {
+	const contract = "0x1A92f7381B9F03921564a437210bB9396471050C"
+	gold := Trait{Type: "Background", Value: "Gold"}
+	laser := Trait{Type: "Eyes", Value: "Laser"}
+	blue := Trait{Type: "Eyes", Value: "Blue"}
+	store := newMemTraitStore()
+	store.traits[assetKey(contract, "1")] = []Trait{gold, laser}
+	var fetched []string
+	book := newTraitBook(time.Hour, 2, store, func(ctx context.Context, contract, tokenId string) ([]Trait, error) {
+		fetched = append(fetched, tokenId)
+		switch tokenId {
+		case "2":
+			return []Trait{gold, blue}, nil
+		case "4", "5":
+			return []Trait{gold, laser}, nil
+		case "6":
+			return nil, errors.New("not found")
+		default:
+			return nil, nil // unrevealed
+		}
+	}, zap.NewNop().Sugar())
+	// an expired listing saved before
+	store.listings[assetKey(contract, "9")] = listing{Key: assetKey(contract, "9"), Slug: "a", ETH: eth("0.1"),
+		Traits: []Trait{gold}, ListedAt: time.Now().Add(-2 * time.Hour)}
+	if err := book.load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(id, price string) Record {
+		return Record{Event: EventList, Slug: "a", Contract: contract, Id: id, Price: eth(price), ETH: eth(price)}
+	}
+	// newest first
+	records := []Record{list("3", "1"), list("2", "1"), list("1", "3")}
+	book.stamp(context.Background(), records, "")
+	if strings.Join(fetched, ",") != "3,2" {
+		t.Errorf("fetched = %v", fetched)
+	}
+	if len(records[0].Traits) != 0 || len(records[1].Traits) != 2 || len(records[2].Traits) != 2 {
+		t.Errorf("traits = %v, %v, %v", records[0].Traits, records[1].Traits, records[2].Traits)
+	}
+	if len(store.traits) != 2 {
+		t.Errorf("cached traits = %v", store.traits)
+	}
+	// token 1 is the first listing, token 2 has the floor of gold
+	if records[2].TraitFloor != nil || records[1].TraitFloor == nil || records[1].TraitFloor.Price.String() != "3" {
+		t.Errorf("trait floors = %+v, %+v", records[2].TraitFloor, records[1].TraitFloor)
+	}
+
+	// token 4: gold floor 1 (token 2), laser floor 3 (token 1), the higher one
+	records = []Record{list("6", "1"), list("4", "0.5"), list("5", "1")}
+	book.stamp(context.Background(), records, "")
+	if f := records[1].TraitFloor; f == nil || f.Type != "Eyes" || f.Value != "Laser" || f.Price.String() != "3" {
+		t.Errorf("trait floor = %+v", f)
+	}
+	// at most 2 tokens fetched in one batch, token 5 is not listed without traits
+	if strings.Join(fetched, ",") != "3,2,6,4" || records[2].TraitFloor != nil {
+		t.Errorf("fetched = %v", fetched)
+	}
+
+	// token 1 is sold, token 4 of laser is listed at 0.5 now
+	records = []Record{list("5", "2"), {Event: EventSale, Slug: "a", Contract: contract, Id: "1"}}
+	book.stamp(context.Background(), records, "")
+	if f := records[0].TraitFloor; f == nil || f.Type != "Background" || f.Price.String() != "0.5" {
+		t.Errorf("trait floor = %+v", f)
+	}
+	var keys []string
+	for k := range store.listings {
+		keys = append(keys, strings.TrimPrefix(k, "asset:"+contract+":"))
+	}
+	sort.Strings(keys)
+	// the expired listing is left to the TTL index
+	if strings.Join(keys, ",") != "2,4,5,9" {
+		t.Errorf("listings = %v", keys)
+	}
+
+	// another collection, the slug of the target
+	records = []Record{list("7", "1")}
+	records[0].Slug = ""
+	records[0].Traits = []Trait{gold}
+	book.stamp(context.Background(), records, "b")
+	if records[0].TraitFloor != nil || store.listings[assetKey(contract, "7")].Slug != "b" {
+		t.Errorf("trait floor = %+v, listing = %+v", records[0].TraitFloor, store.listings[assetKey(contract, "7")])
+	}
+
+	// a replayed listing of 2 hours ago is expired, token 5 is cancelled, the cancel of token 4 is older than its listing
+	old := list("8", "0.1")
+	old.Traits = []Trait{gold}
+	old.Time = time.Now().Add(-2 * time.Hour)
+	cancel := func(id string, at time.Time) Record {
+		return Record{Event: EventListCancel, Slug: "a", Contract: contract, Id: id, Time: at}
+	}
+	records = []Record{cancel("5", time.Now()), cancel("4", time.Now().Add(-time.Hour)), old}
+	book.stamp(context.Background(), records, "")
+	if f := records[2].TraitFloor; f == nil || f.Price.String() != "0.5" {
+		t.Errorf("trait floor = %+v", f)
+	}
+	keys = keys[:0]
+	for k := range book.listings["a"] {
+		keys = append(keys, strings.TrimPrefix(k, "asset:"+contract+":"))
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "2,4" {
+		t.Errorf("listings = %v", keys)
+	}
+}
+
+func eth(s string) *Decimal {
+	return NewDecimal(decimal.RequireFromString(s))
+}
@@ -4,45 +4,64 @@ import (
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/shopspring/decimal"
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"sort"
+	"strconv"
 	"time"
 )
 
-func toRecord(ae AssetEvent) Record {
+func toRecord(ae api.AssetEvent) Record {
 	r := Record{
 		Collection:      ae.Asset.Collection.Name,
+		Slug:            ae.Asset.Collection.Slug,
 		Contract:        common.HexToAddress(ae.Asset.AssetContract.Address).Hex(),
 		Name:            ae.Asset.Name,
 		Id:              ae.Asset.TokenId,
-		From:            ae.FromAccount.String(),
-		Date:            toBeijingTime(ae.CreatedDate),
+		Quantity:        quantity(ae.Quantity),
 		CreatedAt:       time.Now(),
 		ImagePreviewUrl: ae.Asset.ImagePreviewUrl,
 	}
+	r.Traits = traits(ae.Asset.Traits)
+	if t, ok := eventTime(ae); ok {
+		r.Time = t
+	}
+	r.FromName, r.FromAddress = account(ae.FromAccount)
+	if ae.Id != 0 {
+		r.EventId = strconv.FormatInt(ae.Id, 10)
+	}
+	if ae.Transaction != nil {
+		r.TxHash = ae.Transaction.TransactionHash
+	}
+	payment := token(ae.PaymentToken)
 	switch ae.EventType {
-	case EventTypeTransfer:
-		if ae.FromAccount.Address == "0x0000000000000000000000000000000000000000" {
+	case api.EventTypeTransfer:
+		if r.FromAddress == (common.Address{}).Hex() {
 			r.Event = EventMint
 		} else {
 			r.Event = EventTransfer
 		}
+		r.ToName, r.ToAddress = account(ae.ToAccount)
 		// Mint 和 Transfer 都没有价格需要展示
-	case EventTypeList:
+	case api.EventTypeList:
 		r.Event = EventList
-		r.Price = toEther(ae.EndingPrice, ae.PaymentToken)
-	case EventTypeBid:
+		r.setPrice(ae.EndingPrice, payment)
+	case api.EventTypeBid:
 		r.Event = EventBid
-		r.Price = toEther(ae.BidAmount, ae.PaymentToken)
-	case EventTypeBidCancel:
+		r.setPrice(ae.BidAmount, payment)
+	case api.EventTypeBidCancel:
 		r.Event = EventBidCancel
-		r.Price = toEther(ae.TotalPrice, ae.PaymentToken)
-	case EventTypeSale:
+		r.setPrice(ae.TotalPrice, payment)
+	case api.EventTypeCancel:
+		r.Event = EventListCancel
+		r.setPrice(ae.TotalPrice, payment)
+	case api.EventTypeSale:
 		r.Event = EventSale
-		r.Price = toEther(ae.TotalPrice, ae.PaymentToken)
-		r.From = ae.Seller.String()
-		r.To = ae.WinnerAccount.String()
-	case EventTypeOffer:
+		r.setPrice(ae.TotalPrice, payment)
+		r.FromName, r.FromAddress = account(ae.Seller)
+		r.ToName, r.ToAddress = account(ae.WinnerAccount)
+	case api.EventTypeOffer:
 		r.Event = EventOffer
-		r.Price = toEther(ae.BidAmount, ae.PaymentToken)
+		r.setPrice(ae.BidAmount, payment)
 	default:
 		r.Event = ae.EventType
 	}
@@ -50,25 +69,60 @@ func toRecord(ae AssetEvent) Record {
 	return r
 }
 
-// "2021-08-28T09:44:43.664713"
-func toBeijingTime(date string) string {
-	//secondsEastOfUTC := int((8 * time.Hour).Seconds())
-	//beijing := time.FixedZone("Beijing Time", secondsEastOfUTC)
-	layout := "2006-01-02T15:04:05.999999"
-	t, err := time.Parse(layout, date)
-	if err != nil {
-		return date
+// traits converts the traits of the API, numbers to text.
+func traits(ts []api.Trait) []Trait {
+	var traits []Trait
+	for _, t := range ts {
+		traits = append(traits, Trait{Type: t.TraitType, Value: fmt.Sprint(t.Value)})
 	}
-	onlyTime := "15:04:05"
-	return t.Local().Format(onlyTime)
+	return traits
+}
+
+// account returns the user name and the full address of the account, both empty for nil.
+func account(a *api.Account) (string, string) {
+	if a == nil || a.Address == "" {
+		return "", ""
+	}
+	return a.User.Username, common.HexToAddress(a.Address).Hex()
+}
+
+// token converts the payment token of the API, the address in checksum format.
+// The rates are nil if not reported.
+func token(p api.PaymentToken) Token {
+	t := Token{Symbol: p.Symbol, Decimals: p.Decimals}
+	if p.Address != "" {
+		t.Address = common.HexToAddress(p.Address).Hex()
+	}
+	if d, err := decimal.NewFromString(p.EthPrice); err == nil {
+		t.ETHPrice = NewDecimal(d)
+	}
+	if d, err := decimal.NewFromString(p.UsdPrice); err == nil {
+		t.USDPrice = NewDecimal(d)
+	}
+	return t
+}
+
+// quantity parses the number of tokens of an event, 1 if empty or invalid.
+func quantity(s string) int64 {
+	q, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || q <= 0 {
+		return 1
+	}
+	return q
 }
 
-func toEther(price string, payment PaymentToken) string {
-	unit := decimal.New(1, int32(payment.Decimals))
-	d, err := decimal.NewFromString(price)
-	if err != nil {
-		return price
+// sortedKeys returns keys of projects in order, so projects are polled in the same order every round.
+func sortedKeys(projects map[string]Project) []string {
+	keys := make([]string, 0, len(projects))
+	for k := range projects {
+		keys = append(keys, k)
 	}
-	ret := fmt.Sprintf("%s %s", d.Div(unit).String(), payment.Symbol)
-	return ret
+	sort.Strings(keys)
+	return keys
+}
+
+// localTime is the time of day shown in messages.
+func localTime(t time.Time) string {
+	onlyTime := "15:04:05"
+	return t.Local().Format(onlyTime)
 }
@@ -1,14 +1,57 @@
 package opensea
 
-import "testing"
+import (
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"testing"
+	"time"
+)
 
-func TestBeijingTime(t *testing.T) {
-	tests := []string{
-		"2021-08-15T05:34:52.669499",
-		"2021-08-15T08:00:37.309132",
-		"2021-08-28T09:44:43.664713",
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		date string
+		want time.Time
+	}{
+		{"2021-08-15T05:34:52.669499", time.Date(2021, 8, 15, 5, 34, 52, 669499000, time.UTC)},
+		{"2021-08-28T09:44:43", time.Date(2021, 8, 28, 9, 44, 43, 0, time.UTC)},
 	}
 	for _, tt := range tests {
-		t.Logf("%s => %s", tt, toBeijingTime(tt))
+		if got, ok := parseTime(tt.date); !ok || !got.Equal(tt.want) {
+			t.Errorf("parseTime(%s) = %s", tt.date, got)
+		}
+	}
+	if _, ok := parseTime("15:04:05"); ok {
+		t.Error("time of day should be error")
+	}
+}
+
+func TestToRecord(t *testing.T) {
+	r := toRecord(api.AssetEvent{
+		Id:         789,
+		EventType:  api.EventTypeSale,
+		Asset:      api.Asset{TokenId: "7", AssetContract: api.AssetContract{Address: "0x1a92f7381b9f03921564a437210bb9396471050c"}},
+		TotalPrice: "3000000",
+		Quantity:   "3",
+		PaymentToken: api.PaymentToken{Symbol: "USDC", Decimals: 6,
+			Address: "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", EthPrice: "0.000500000000000", UsdPrice: "1.001000000000000"},
+		EventTimestamp: "2021-08-15T05:33:10",
+		CreatedDate:    "2021-08-15T05:34:52.669499",
+		Seller:         &api.Account{Address: "0x1a92f7381b9f03921564a437210bb9396471050c", User: api.User{Username: "alice"}},
+		WinnerAccount:  &api.Account{Address: "0x0000000000000000000000000000000000000001"},
+	})
+	if priceText(r) != "3 USDC" || r.USD == nil || r.USD.String() != "3.003" || r.ETH == nil || r.ETH.String() != "0.0015" ||
+		r.Quantity != 3 ||
+		r.Payment.Address != "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48" {
+		t.Errorf("price = %s, value = %s, quantity = %d", priceText(r), valueText(r), r.Quantity)
+	}
+	if r.FromName != "alice" || r.FromAddress != "0x1A92f7381B9F03921564a437210bB9396471050C" ||
+		r.ToAddress != "0x0000000000000000000000000000000000000001" {
+		t.Errorf("from %s %s, to %s", r.FromName, r.FromAddress, r.ToAddress)
+	}
+	// when the event happened, not when OpenSea recorded it
+	if !r.Time.Equal(time.Date(2021, 8, 15, 5, 33, 10, 0, time.UTC)) {
+		t.Errorf("time = %s", r.Time)
+	}
+	if got := accountText(r.FromName, r.FromAddress); got != "alice(0x1A92...050C)" {
+		t.Errorf("account text = %s", got)
 	}
 }
@@ -0,0 +1,66 @@
+package opensea
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"sort"
+)
+
+// wallets is a set of tracked wallet addresses, in checksum format.
+type wallets map[string]bool
+
+func newWallets(addresses []string) (wallets, error) {
+	w := make(wallets, len(addresses))
+	for _, a := range addresses {
+		if !common.IsHexAddress(a) {
+			return nil, fmt.Errorf("wallet %s is not an address", a)
+		}
+		w[common.HexToAddress(a).Hex()] = true
+	}
+	return w, nil
+}
+
+// add adds the wallets of chats, invalid addresses are skipped.
+func (w wallets) add(addresses []string) {
+	for _, a := range addresses {
+		if common.IsHexAddress(a) {
+			w[common.HexToAddress(a).Hex()] = true
+		}
+	}
+}
+
+func walletKey(address string) string {
+	return "wallet:" + address
+}
+
+// targets polls every wallet in all event types.
+func (w wallets) targets() []target {
+	addresses := make([]string, 0, len(w))
+	for a := range w {
+		addresses = append(addresses, a)
+	}
+	sort.Strings(addresses)
+	targets := make([]target, 0, len(addresses))
+	for _, a := range addresses {
+		targets = append(targets, target{
+			key:   walletKey(a),
+			query: Query{Account: a},
+		})
+	}
+	return targets
+}
+
+// mark sets the tracked wallets taking part in the events.
+// Every record is marked, no matter which target fetched it,
+// so the marker is kept when the same event is polled by the collection first.
+func (w wallets) mark(records []Record) {
+	for i := range records {
+		r := &records[i]
+		r.Wallets = nil
+		for _, a := range []string{r.FromAddress, r.ToAddress} {
+			if a != "" && w[a] {
+				r.Wallets = append(r.Wallets, a)
+			}
+		}
+	}
+}
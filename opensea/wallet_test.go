@@ -0,0 +1,44 @@
+package opensea
+
+import (
+	"github.com/xyths/opensea-monitor/opensea/api"
+	"reflect"
+	"testing"
+)
+
+func TestWallets(t *testing.T) {
+	if _, err := newWallets([]string{"whale"}); err == nil {
+		t.Error("invalid address should be error")
+	}
+	w, err := newWallets([]string{"0x1a92f7381b9f03921564a437210bb9396471050c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.add([]string{"0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d", "whale"})
+	want := []target{
+		{key: "wallet:0x1A92f7381B9F03921564a437210bB9396471050C", query: Query{Account: "0x1A92f7381B9F03921564a437210bB9396471050C"}},
+		{key: "wallet:0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D", query: Query{Account: "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"}},
+	}
+	if got := w.targets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("targets = %v, want %v", got, want)
+	}
+
+	records := []Record{
+		toRecord(api.AssetEvent{
+			EventType:     api.EventTypeSale,
+			Seller:        &api.Account{Address: "0x1a92f7381b9f03921564a437210bb9396471050c"},
+			WinnerAccount: &api.Account{Address: "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"},
+		}),
+		toRecord(api.AssetEvent{
+			EventType:   api.EventTypeList,
+			FromAccount: &api.Account{Address: "0x0000000000000000000000000000000000000001"},
+		}),
+	}
+	w.mark(records)
+	if len(records[0].Wallets) != 2 {
+		t.Errorf("sale wallets = %v", records[0].Wallets)
+	}
+	if records[1].Wallets != nil {
+		t.Errorf("list wallets = %v", records[1].Wallets)
+	}
+}
@@ -0,0 +1,116 @@
+package opensea
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	collWatermark = "watermarks"
+
+	watermarkIndexName = "watermarkProjectSourceIndex"
+
+	// SourceOpenSea is the event source of OpenSea events API.
+	SourceOpenSea = "opensea"
+
+	// blockWatermark is the project of a block watermark, one for all contracts of the source.
+	blockWatermark = "*"
+)
+
+// Watermark is the end of the last successful fetch window of one project from one event source,
+// the next fetch of the project starts from here.
+type Watermark struct {
+	Project      string    `bson:"project"` // contract address
+	Source       string    `bson:"source"`
+	Value        time.Time `bson:"value"`
+	Block        uint64    `bson:"block,omitempty"` // the last block read, for on-chain sources
+	LastModified time.Time `bson:"lastModified"`
+}
+
+func (s *OpenSea) initWatermarkIndex(ctx context.Context) error {
+	coll := s.db.Collection(collWatermark)
+	index := mongo.IndexModel{
+		Keys:    bson.D{{"project", 1}, {"source", 1}},
+		Options: options.Index().SetUnique(true).SetName(watermarkIndexName),
+	}
+	_, err := coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+// loadWatermarks loads watermarks of all projects of the source, keyed by project.
+func (s *OpenSea) loadWatermarks(ctx context.Context, source string) (map[string]time.Time, error) {
+	coll := s.db.Collection(collWatermark)
+	cur, err := coll.Find(ctx, bson.D{{"source", source}})
+	if err != nil {
+		return nil, err
+	}
+	var records []Watermark
+	if err = cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	watermarks := make(map[string]time.Time, len(records))
+	for _, w := range records {
+		watermarks[w.Project] = w.Value
+	}
+	return watermarks, nil
+}
+
+func (s *OpenSea) saveWatermark(ctx context.Context, project, source string, value time.Time) error {
+	coll := s.db.Collection(collWatermark)
+	_, err := coll.UpdateOne(
+		ctx,
+		bson.D{
+			{"project", project},
+			{"source", source},
+		},
+		bson.D{
+			{"$set", bson.D{
+				{"value", value},
+			}},
+			{"$currentDate", bson.D{
+				{"lastModified", true},
+			}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// loadBlockWatermark loads the last block read of an on-chain source, false if never read.
+func (s *OpenSea) loadBlockWatermark(ctx context.Context, source string) (uint64, bool, error) {
+	coll := s.db.Collection(collWatermark)
+	var w Watermark
+	err := coll.FindOne(ctx, bson.D{{"project", blockWatermark}, {"source", source}}).Decode(&w)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return w.Block, true, nil
+}
+
+func (s *OpenSea) saveBlockWatermark(ctx context.Context, source string, block uint64) error {
+	coll := s.db.Collection(collWatermark)
+	_, err := coll.UpdateOne(
+		ctx,
+		bson.D{
+			{"project", blockWatermark},
+			{"source", source},
+		},
+		bson.D{
+			{"$set", bson.D{
+				{"block", int64(block)},
+			}},
+			{"$currentDate", bson.D{
+				{"lastModified", true},
+			}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
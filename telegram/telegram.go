@@ -1,237 +1,297 @@
 package telegram
 
-//import (
-//	"context"
-//	"errors"
-//	"github.com/go-telegram-bot-api/telegram-bot-api"
-//	"github.com/xyths/hs"
-//	"github.com/xyths/opensea-monitor/opensea"
-//	"go.mongodb.org/mongo-driver/bson"
-//	"go.mongodb.org/mongo-driver/mongo"
-//	"go.mongodb.org/mongo-driver/mongo/options"
-//	"go.uber.org/zap"
-//	"time"
-//)
-//
-//const keyLastReadTime = "lastRead"
-//
-//type Config struct {
-//	Mongo    hs.MongoConf
-//	Log      hs.LogConf
-//	Bot      string // bot username
-//	Token    string
-//	Table    string // MongoDB collection name for bot config
-//	Interval string
-//}
-//
-//type Bot struct {
-//	cfg Config
-//
-//	interval time.Duration
-//
-//	Sugar *zap.SugaredLogger
-//	db    *mongo.Database
-//	bot   *tgbotapi.BotAPI
-//}
-//
-//func New(cfg Config) *Bot {
-//	return &Bot{cfg: cfg}
-//}
-//
-//func (b *Bot) Init(ctx context.Context) error {
-//	l, err := hs.NewZapLogger(b.cfg.Log)
-//	if err != nil {
-//		return err
-//	}
-//	b.Sugar = l.Sugar()
-//	b.Sugar.Info("logger initialized")
-//	b.interval, err = time.ParseDuration(b.cfg.Interval)
-//	if err != nil {
-//		b.Sugar.Errorf("interval %s format error: %s", b.cfg.Interval, err)
-//		return err
-//	}
-//	db, err := hs.ConnectMongo(ctx, b.cfg.Mongo)
-//	if err != nil {
-//		b.Sugar.Errorf("connect mongo error: %s", err)
-//		return err
-//	}
-//	b.db = db
-//	b.Sugar.Info("database initialized")
-//	b.bot, err = tgbotapi.NewBotAPI(b.cfg.Token)
-//	if err != nil {
-//		b.Sugar.Errorf("New Telegram bot error: %s", err)
-//		return err
-//	}
-//	b.Sugar.Info("Bot initialized")
-//	return nil
-//}
-//
-//func (b *Bot) Close(ctx context.Context) {
-//	if err := b.db.Client().Disconnect(ctx); err != nil {
-//		b.Sugar.Errorf("db close error: %s", err)
-//	}
-//	b.Sugar.Info("Bot closed")
-//}
-//
-//func (b *Bot) Serve(ctx context.Context) error {
-//	if err := b.doWork(ctx); err != nil {
-//		b.Sugar.Errorf("doWork error: %s", err)
-//	}
-//	for {
-//		select {
-//		case <-ctx.Done():
-//			return ctx.Err()
-//		case <-time.After(b.interval):
-//			if err := b.doWork(ctx); err != nil {
-//				b.Sugar.Errorf("doWork error: %s", err)
-//			}
-//		}
-//	}
-//}
-//
-//func (b *Bot) doWork(ctx context.Context) error {
-//	b.Sugar.Info("doWork start")
-//	defer b.Sugar.Info("doWork finish")
-//
-//	records, err := b.getLatestEvents(ctx)
-//	if err != nil {
-//		return err
-//	}
-//	if records == nil {
-//		return nil
-//	}
-//	//chats, err := b.getAvailableChats(ctx)
-//	//if err != nil {
-//	//	return err
-//	//}
-//	//for _, c := range chats {
-//	//	if err = b.dispatch(ctx, c, records); err != nil {
-//	//		b.Sugar.Errorf("dispatch error: %s", err)
-//	//	}
-//	//}
-//	return nil
-//}
-//
-//func (b *Bot) getLatestEvents(ctx context.Context) ([]opensea.Record, error) {
-//	coll := b.db.Collection(opensea.CollEvent)
-//	var now time.Time
-//
-//	if last, err := b.loadLastReadTime(ctx); err != nil || last == nil {
-//		now = time.Now()
-//	} else {
-//		now = *last
-//	}
-//	cur, err := coll.Find(ctx,
-//		bson.D{
-//			{"createdAt", bson.D{
-//				{"gt", now},
-//			}},
-//		})
-//	if err != nil {
-//		if errors.Is(err, mongo.ErrNoDocuments) {
-//			return nil, nil
-//		} else {
-//			return nil, err
-//		}
-//	}
-//	var records []opensea.Record
-//	if err = cur.All(ctx, &records); err != nil {
-//		return nil, err
-//	}
-//	return records, nil
-//}
-//
-//func (b *Bot) loadLastReadTime(ctx context.Context) (*time.Time, error) {
-//	coll := b.db.Collection(b.cfg.Table)
-//	last := struct {
-//		Key          string    `bson:"key"`
-//		Value        time.Time `bson:"value"`
-//		LastModified time.Time `bson:"lastModified"`
-//	}{}
-//	if err := coll.FindOne(ctx, bson.D{{"key", keyLastReadTime}}).Decode(&last); err == nil {
-//		return &last.Value, nil
-//	} else if errors.Is(err, mongo.ErrNoDocuments) {
-//		return nil, nil
-//	} else {
-//		return nil, err
-//	}
-//}
-//
-//func (b *Bot) saveLastReadTime(ctx context.Context, now time.Time) error {
-//	coll := b.db.Collection(b.cfg.Table)
-//
-//	if _, err := coll.UpdateOne(
-//		ctx,
-//		bson.D{
-//			{"key", keyLastReadTime},
-//		},
-//		bson.D{
-//			{"$set", bson.D{
-//				{"value", now},
-//			}},
-//			{"$currentDate", bson.D{
-//				{"lastModified", true},
-//			}},
-//		},
-//		options.Update().SetUpsert(true),
-//	); err != nil {
-//		return err
-//	}
-//	return nil
-//}
-//
-//func (b *Bot) getAvailableChats(ctx context.Context) ([]Configuration, error) {
-//	coll := b.db.Collection(CollPreferences)
-//	var chats []Configuration
-//	cur, err := coll.Find(ctx,
-//		bson.D{
-//			{"bot", b.cfg.Bot},
-//		},
-//	)
-//	if err != nil {
-//		if errors.Is(err, mongo.ErrNoDocuments) {
-//			return nil, nil
-//		}
-//		return nil, err
-//	}
-//	if err = cur.All(ctx, &chats); err != nil {
-//		return nil, err
-//	}
-//	return chats, nil
-//}
-//
-////func (b *Bot) dispatch(ctx context.Context, chat Configuration, records []opensea.Record) error {
-////	var filter bool
-////	projects := make(map[string]bool)
-////	for _, p := range chat.Projects {
-////		projects[common.HexToAddress(p.Address).Hex()] = true
-////	}
-////	if len(projects) > 0 {
-////		filter = true
-////	}
-////	for _, r := range records {
-////		if filter {
-////			if _, ok := projects[r.Contract]; !ok {
-////				continue
-////			}
-////		}
-////		content := format(r, chat.Options)
-////		msg := tgbotapi.NewMessage(chat.ChatId, content)
-////		if _, err := b.bot.Send(msg); err != nil {
-////			b.Sugar.Errorf("send message error: %s", err)
-////		}
-////	}
-////
-////	return nil
-////}
-//
-////func (b *Bot) sendMessage(chatId int64, record op) {
-////
-////}
-//
-//func format(record opensea.Record, options Options) string {
-//	//switch record.Event {
-//	//case
-//	//}
-//	return ""
-//}
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/xyths/hs"
+	"github.com/xyths/opensea-monitor/opensea"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	collCursor = "cursors"
+
+	defaultInterval = time.Second * 2
+	// settle is how long the bot keeps away from the newest events,
+	// so an event inserted a little late is not skipped by the cursor.
+	settle = time.Second * 2
+	// batchSize is the max events read one time.
+	batchSize = 1000
+)
+
+// Config is the part of the config file used by the bot, it shares the file with `event monitor`.
+type Config struct {
+	Mongo    hs.MongoConf
+	Log      hs.LogConf
+	Telegram opensea.TelegramConf
+	// Wallets are the global tracked wallets of `event monitor`, delivered to every chat.
+	Wallets []string `json:"wallets"`
+}
+
+// Bot tails the events collection saved by `event monitor` and sends them to Telegram chats.
+// Every bot has its own read cursor, so it can be restarted without re-polling OpenSea.
+type Bot struct {
+	cfg Config
+
+	interval time.Duration
+
+	Sugar *zap.SugaredLogger
+	db    *mongo.Database
+	bot   *tgbotapi.BotAPI
+}
+
+// Cursor is the position of the last event read by a bot, in `createdAt` and `_id` order.
+type Cursor struct {
+	Bot          string             `bson:"bot"`
+	CreatedAt    time.Time          `bson:"createdAt"`
+	Id           primitive.ObjectID `bson:"id"`
+	LastModified time.Time          `bson:"lastModified"`
+}
+
+// event is the stored record with its `_id`.
+type event struct {
+	Id             primitive.ObjectID `bson:"_id"`
+	opensea.Record `bson:",inline"`
+}
+
+// UnmarshalBSON decodes the id besides the record, the promoted Record.UnmarshalBSON decodes the record only.
+func (e *event) UnmarshalBSON(data []byte) error {
+	id, ok := bson.Raw(data).Lookup("_id").ObjectIDOK()
+	if !ok {
+		return errors.New("event without ObjectID")
+	}
+	e.Id = id
+	return e.Record.UnmarshalBSON(data)
+}
+
+func New(cfg Config) *Bot {
+	return &Bot{cfg: cfg}
+}
+
+func (b *Bot) Init(ctx context.Context) error {
+	l, err := hs.NewZapLogger(b.cfg.Log)
+	if err != nil {
+		return err
+	}
+	b.Sugar = l.Sugar()
+	b.Sugar.Info("logger initialized")
+	b.interval = defaultInterval
+	if b.cfg.Telegram.Interval != "" {
+		b.interval, err = time.ParseDuration(b.cfg.Telegram.Interval)
+		if err != nil {
+			b.Sugar.Errorf("interval %s format error: %s", b.cfg.Telegram.Interval, err)
+			return err
+		}
+	}
+	db, err := hs.ConnectMongo(ctx, b.cfg.Mongo)
+	if err != nil {
+		b.Sugar.Errorf("connect mongo error: %s", err)
+		return err
+	}
+	b.db = db
+	b.Sugar.Info("database initialized")
+	b.bot, err = tgbotapi.NewBotAPI(b.cfg.Telegram.Token)
+	if err != nil {
+		b.Sugar.Errorf("New Telegram bot error: %s", err)
+		return err
+	}
+	b.Sugar.Info("Bot initialized")
+	return nil
+}
+
+func (b *Bot) Close(ctx context.Context) {
+	if err := b.db.Client().Disconnect(ctx); err != nil {
+		b.Sugar.Errorf("db close error: %s", err)
+	}
+	b.Sugar.Info("Bot closed")
+}
+
+func (b *Bot) Serve(ctx context.Context) error {
+	if err := b.doWork(ctx); err != nil {
+		b.Sugar.Errorf("doWork error: %s", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(b.interval):
+			if err := b.doWork(ctx); err != nil {
+				b.Sugar.Errorf("doWork error: %s", err)
+			}
+		}
+	}
+}
+
+func (b *Bot) doWork(ctx context.Context) error {
+	cursor, err := b.loadCursor(ctx)
+	if err != nil {
+		return err
+	}
+	if cursor == nil {
+		// first start, don't flood chats with old events
+		cursor = &Cursor{Bot: b.cfg.Telegram.Bot, CreatedAt: time.Now().Add(-settle)}
+		b.Sugar.Infof("no cursor, start from %s", cursor.CreatedAt)
+	}
+	events, err := b.getLatestEvents(ctx, cursor)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	b.Sugar.Infof("read %d events after %s", len(events), cursor.CreatedAt)
+	chats, err := opensea.LoadChats(ctx, b.db, b.cfg.Telegram.Bot)
+	if err != nil {
+		return err
+	}
+	for _, c := range chats {
+		if c.FilterErr != nil {
+			b.Sugar.Errorf("chat %d is not served, filter error: %s", c.ChatId, c.FilterErr)
+			continue
+		}
+		c.Wallets = append(c.Wallets, b.cfg.Wallets...)
+		if err = b.dispatch(ctx, c, events); err != nil {
+			b.Sugar.Errorf("dispatch error: %s", err)
+		}
+	}
+	last := events[len(events)-1]
+	cursor.CreatedAt, cursor.Id = last.CreatedAt, last.Id
+	return b.saveCursor(ctx, cursor)
+}
+
+// getLatestEvents reads events after the cursor, in `createdAt` and `_id` order.
+func (b *Bot) getLatestEvents(ctx context.Context, cursor *Cursor) ([]event, error) {
+	coll := b.db.Collection(opensea.CollEvent)
+	cur, err := coll.Find(ctx,
+		bson.D{
+			{"$or", bson.A{
+				bson.D{{"createdAt", bson.D{{"$gt", cursor.CreatedAt}}}},
+				bson.D{
+					{"createdAt", cursor.CreatedAt},
+					{"_id", bson.D{{"$gt", cursor.Id}}},
+				},
+			}},
+			{"createdAt", bson.D{{"$lte", time.Now().Add(-settle)}}},
+		},
+		options.Find().SetSort(bson.D{{"createdAt", 1}, {"_id", 1}}).SetLimit(batchSize),
+	)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var events []event
+	if err = cur.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// getBatch reads the records of the catch-up batch.
+func (b *Bot) getBatch(ctx context.Context, batch string) ([]opensea.Record, error) {
+	coll := b.db.Collection(opensea.CollEvent)
+	cur, err := coll.Find(ctx,
+		bson.D{
+			{"catchUp", batch},
+			{"event", bson.D{{"$ne", opensea.EventDigest}}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	var records []opensea.Record
+	if err = cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (b *Bot) loadCursor(ctx context.Context) (*Cursor, error) {
+	coll := b.db.Collection(collCursor)
+	var cursor Cursor
+	if err := coll.FindOne(ctx, bson.D{{"bot", b.cfg.Telegram.Bot}}).Decode(&cursor); err == nil {
+		return &cursor, nil
+	} else if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	} else {
+		return nil, err
+	}
+}
+
+func (b *Bot) saveCursor(ctx context.Context, cursor *Cursor) error {
+	coll := b.db.Collection(collCursor)
+
+	if _, err := coll.UpdateOne(
+		ctx,
+		bson.D{
+			{"bot", b.cfg.Telegram.Bot},
+		},
+		bson.D{
+			{"$set", bson.D{
+				{"createdAt", cursor.CreatedAt},
+				{"id", cursor.Id},
+			}},
+			{"$currentDate", bson.D{
+				{"lastModified", true},
+			}},
+		},
+		options.Update().SetUpsert(true),
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (b *Bot) dispatch(ctx context.Context, chat opensea.Configuration, events []event) error {
+	var count int
+	for _, e := range events {
+		// send all message even if cancelled context
+		var content string
+		switch {
+		case e.Event == opensea.EventDigest:
+			if e.Digest == nil {
+				continue
+			}
+			records, err := b.getBatch(ctx, e.CatchUp)
+			if err != nil {
+				b.Sugar.Errorf("get catch-up batch %s error: %s", e.CatchUp, err)
+				continue
+			}
+			var wanted []opensea.Record
+			for _, r := range records {
+				if chat.Wants(r) {
+					wanted = append(wanted, r)
+				}
+			}
+			if len(wanted) == 0 {
+				continue
+			}
+			content = opensea.Digest(wanted, e.Digest.From, e.Digest.To)
+		case e.CatchUp != "":
+			// delivered in the digest
+			continue
+		case !chat.Wants(e.Record):
+			b.Sugar.Debugf("event contract filtered: %s %s", e.Collection, e.Contract)
+			continue
+		default:
+			content = opensea.Format(e.Record, chat.Options)
+		}
+		msg := tgbotapi.NewMessage(chat.ChatId, content)
+		if _, err := b.bot.Send(msg); err != nil {
+			b.Sugar.Errorf("send message error: %s", err)
+		}
+		count++
+		if count == 100 {
+			time.Sleep(time.Second * 10)
+			count = 0
+		}
+	}
+	return nil
+}